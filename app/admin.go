@@ -0,0 +1,64 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	gcontext "mmr/context"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type rolesRequest struct {
+	Roles []string `json:"roles" validate:"required"`
+}
+
+func (a *App) getUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dbUsr, cerr := a.usrSvc.Find(int32(id))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(dbUsr); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *App) setRoles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req rolesRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	dbUsr, cerr := a.usrSvc.SetRoles(gcontext.GetUserID(r.Context()), int32(id), req.Roles)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(dbUsr); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+}
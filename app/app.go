@@ -1,27 +1,67 @@
 package app
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"log"
+	"math"
+	"mmr/chat"
+	Cerr "mmr/errors"
+	"mmr/keys"
+	"mmr/models"
 	"mmr/services"
+	"mmr/shared"
 	"net/http"
+	"os"
+	"strconv"
 )
 
 type App struct {
-	r       *mux.Router
-	usrSvc  *services.User
-	ctgSvc  *services.Category
-	authSvc *services.Auth
+	r         *mux.Router
+	usrSvc    *services.User
+	ctgSvc    *services.Category
+	authSvc   *services.Auth
+	mmSvc     *services.Matchmaking
+	ratingSvc *services.Rating
+	matchSvc  *services.Match
+	lbSvc     *services.Leaderboard
+	seasonSvc *services.Season
+	hub       *chat.Hub
+	keyMgr    *keys.Manager
+	pwdSvc    *services.Password
+	verifySvc *services.Verification
+	tfSvc     *services.TwoFactor
+	oauthSvc  *services.OAuth
+	magicSvc  *services.MagicLink
 }
 
-func NewApp(usrSvc *services.User, ctgSvc *services.Category, authSvc *services.Auth) *App {
+func NewApp(usrSvc *services.User, ctgSvc *services.Category, authSvc *services.Auth, mmSvc *services.Matchmaking,
+	ratingSvc *services.Rating, matchSvc *services.Match, lbSvc *services.Leaderboard, seasonSvc *services.Season,
+	hub *chat.Hub, keyMgr *keys.Manager, pwdSvc *services.Password,
+	verifySvc *services.Verification, tfSvc *services.TwoFactor, oauthSvc *services.OAuth,
+	magicSvc *services.MagicLink) *App {
 	a := &App{
-		usrSvc:  usrSvc,
-		ctgSvc:  ctgSvc,
-		authSvc: authSvc,
+		usrSvc:    usrSvc,
+		ctgSvc:    ctgSvc,
+		authSvc:   authSvc,
+		mmSvc:     mmSvc,
+		ratingSvc: ratingSvc,
+		matchSvc:  matchSvc,
+		lbSvc:     lbSvc,
+		seasonSvc: seasonSvc,
+		hub:       hub,
+		keyMgr:    keyMgr,
+		pwdSvc:    pwdSvc,
+		verifySvc: verifySvc,
+		tfSvc:     tfSvc,
+		oauthSvc:  oauthSvc,
+		magicSvc:  magicSvc,
 	}
 
 	a.initRoutes()
+	a.initRealtime()
 	return a
 }
 
@@ -34,14 +74,46 @@ func (a *App) initRoutes() {
 
 	//USER
 	userR := a.r.PathPrefix("/users").Subrouter()
-	userR.Use(a.withClaims)
+	userR.Use(a.withAccessClaims)
 	userR.HandleFunc("/me", a.getMe).Methods("GET")
+	userR.HandleFunc("/me", a.updateMe).Methods("PATCH")
+	userR.HandleFunc("/me/password", a.changePassword).Methods("POST")
+	userR.HandleFunc("/me/email", a.changeEmail).Methods("POST")
+	userR.HandleFunc("/me/matches", a.getMyMatches).Methods("GET")
+	userR.HandleFunc("/{id:[0-9]+}/ratings", a.getUserRatings).Methods("GET")
+	userR.HandleFunc("/{id:[0-9]+}/matches", a.getUserMatches).Methods("GET")
 
 	//CATEGORIES
 	categR := a.r.PathPrefix("/categories").Subrouter()
 	categR.HandleFunc("/", a.listCategories).Methods("GET")
 	categR.HandleFunc("/{id:[0-9]+}", a.getCategory).Methods("GET")
 
+	lbR := a.r.PathPrefix("/categories/{id:[0-9]+}/leaderboard").Subrouter()
+	lbR.Use(a.withAccessClaims)
+	lbR.HandleFunc("", a.getLeaderboard).Methods("GET")
+
+	//SEASONS
+	seasonR := a.r.PathPrefix("/seasons").Subrouter()
+	seasonR.Use(a.withAccessClaims)
+	seasonR.HandleFunc("/", a.listSeasons).Methods("GET")
+	seasonR.HandleFunc("/current", a.getCurrentSeason).Methods("GET")
+	seasonR.HandleFunc("/{id:[0-9]+}/standings", a.getStandings).Methods("GET")
+
+	//MATCHMAKING
+	queueR := a.r.PathPrefix("/matchmaking").Subrouter()
+	queueR.Use(a.withAccessClaims)
+	queueR.HandleFunc("/queue", a.joinQueue).Methods("POST")
+	queueR.HandleFunc("/queue", a.leaveQueue).Methods("DELETE")
+	queueR.HandleFunc("/queue", a.queueStatus).Methods("GET")
+
+	//MATCHES
+	matchR := a.r.PathPrefix("/matches").Subrouter()
+	matchR.Use(a.withAccessClaims)
+	matchR.HandleFunc("/current", a.getCurrentMatch).Methods("GET")
+	matchR.HandleFunc("/{id}", a.getMatch).Methods("GET")
+	matchR.HandleFunc("/{id}/result", a.reportResult).Methods("POST")
+	matchR.HandleFunc("/{id}/concede", a.concede).Methods("POST")
+
 	//AUTH
 	authR := a.r.PathPrefix("/auth").Subrouter()
 	authR.Use(a.withValidatedUser)
@@ -49,9 +121,85 @@ func (a *App) initRoutes() {
 	authR.HandleFunc("/register", a.register).Methods("POST")
 
 	tauthR := a.r.PathPrefix("/auth").Subrouter()
-	tauthR.Use(a.withClaims)
+	tauthR.Use(a.withAccessClaims)
 	tauthR.HandleFunc("/logout", a.logout).Methods("POST")
-	tauthR.HandleFunc("/refresh", a.refresh).Methods("POST")
+	tauthR.HandleFunc("/logout-all", a.logoutAll).Methods("POST")
+	tauthR.HandleFunc("/sessions", a.listSessions).Methods("GET")
+	tauthR.HandleFunc("/sessions/{id}", a.revokeSession).Methods("DELETE")
+	tauthR.HandleFunc("/verify/resend", a.resendVerification).Methods("POST")
+	tauthR.HandleFunc("/2fa/enroll", a.enrollTwoFactor).Methods("POST")
+	tauthR.HandleFunc("/2fa/confirm", a.confirmTwoFactor).Methods("POST")
+	tauthR.HandleFunc("/2fa/disable", a.disableTwoFactor).Methods("POST")
+
+	rauthR := a.r.PathPrefix("/auth").Subrouter()
+	rauthR.Use(a.withRefreshClaims)
+	rauthR.HandleFunc("/refresh", a.refresh).Methods("POST")
+
+	pauthR := a.r.PathPrefix("/auth").Subrouter()
+	pauthR.HandleFunc("/password/forgot", a.forgotPassword).Methods("POST")
+	pauthR.HandleFunc("/password/reset", a.resetPassword).Methods("POST")
+	pauthR.HandleFunc("/verify", a.verifyEmail).Methods("POST")
+	pauthR.HandleFunc("/2fa/verify", a.completeLogin).Methods("POST")
+	pauthR.HandleFunc("/magic/request", a.requestMagicLink).Methods("POST")
+	pauthR.HandleFunc("/magic", a.magicLogin).Methods("POST")
+	pauthR.HandleFunc("/oauth", a.listProviders).Methods("GET")
+	pauthR.HandleFunc("/oauth/{provider}", a.startOAuth).Methods("GET")
+	pauthR.HandleFunc("/oauth/{provider}/callback", a.oauthCallback).Methods("GET")
+
+	a.r.HandleFunc("/.well-known/jwks.json", a.getJWKS).Methods("GET")
+
+	//ADMIN
+	adminR := a.r.PathPrefix("/admin").Subrouter()
+	adminR.Use(a.withAccessClaims, a.withPermission(models.PermAdminAccess))
+	adminR.Handle("/users/{id:[0-9]+}", a.withPermission(models.PermModerateUsers)(http.HandlerFunc(a.getUser))).Methods("GET")
+	adminR.Handle("/users/{id:[0-9]+}/roles", a.withPermission(models.PermManageRoles)(http.HandlerFunc(a.setRoles))).Methods("PUT")
+
+	adminCtgR := adminR.PathPrefix("/categories").Subrouter()
+	adminCtgR.Use(a.withPermission(models.PermManageCategories))
+	adminCtgR.HandleFunc("", a.listAllCategories).Methods("GET")
+	adminCtgR.HandleFunc("", a.createCategory).Methods("POST")
+	adminCtgR.HandleFunc("/order", a.reorderCategories).Methods("PUT")
+	adminCtgR.HandleFunc("/{id:[0-9]+}", a.updateCategory).Methods("PUT")
+	adminCtgR.HandleFunc("/{id:[0-9]+}/archive", a.archiveCategory).Methods("POST")
+	adminCtgR.HandleFunc("/{id:[0-9]+}/archive", a.restoreCategory).Methods("DELETE")
+
+	//CHAT
+	wsR := a.r.PathPrefix("/ws").Subrouter()
+	wsR.Use(a.withQueryToken, a.withAccessClaims)
+	wsR.HandleFunc("", a.serveWs).Methods("GET")
 
 	http.Handle("/", a.r)
 }
+
+func (a *App) initRealtime() {
+	a.hub.Handle(msgQueueJoin, a.wsJoinQueue)
+	a.hub.Handle(msgQueueLeave, a.wsLeaveQueue)
+	a.hub.Handle(msgQueueStatus, a.wsQueueStatus)
+	a.hub.Allow(a.matchSvc.AllowsMessage)
+	a.hub.OnConnect(a.matchSvc.Connected)
+	a.hub.OnDisconnect(a.matchSvc.Disconnected)
+}
+
+//writeError responds with the error, telling the client when to retry if it can
+func writeError(w http.ResponseWriter, cerr Cerr.CError) {
+	if retryable, ok := cerr.(Cerr.Retryable); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryable.GetRetryAfter().Seconds()))))
+	}
+	http.Error(w, cerr.Error(), cerr.GetStatusCode())
+}
+
+//decodeRequest decodes and validates the body into req, writing the error response if it fails
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid request: %v\n", err)
+		http.Error(w, "", http.StatusBadRequest)
+		return false
+	}
+	if err := shared.Validate.Struct(req); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err.(validator.ValidationErrors))
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
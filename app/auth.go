@@ -5,27 +5,45 @@ import (
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
 	gcontext "mmr/context"
+	Cerr "mmr/errors"
 	"mmr/models"
+	"mmr/services"
 	"mmr/shared"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 )
 
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token string `json:"token" validate:"required"`
+	Pass  string `json:"pass" validate:"required,gte=6"`
+}
+
+type magicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type tokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
 func (a *App) login(w http.ResponseWriter, r *http.Request) {
 	usr := gcontext.GetUser(r.Context())
-	at, rt, cerr := a.authSvc.Login(usr)
+	res, cerr := a.authSvc.Login(usr, newSession(r))
 	if cerr != nil {
-		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		writeError(w, cerr)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err := json.NewEncoder(w).Encode(map[string]string{
-		"access_token":  at,
-		"refresh_token": rt,
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(res); err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
 		http.Error(w, "", http.StatusInternalServerError)
 	}
@@ -33,7 +51,7 @@ func (a *App) login(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) register(w http.ResponseWriter, r *http.Request) {
 	usr := gcontext.GetUser(r.Context())
-	at, rt, cerr := a.authSvc.Register(usr)
+	at, rt, cerr := a.authSvc.Register(usr, newSession(r))
 	if cerr != nil {
 		http.Error(w, cerr.Error(), cerr.GetStatusCode())
 		return
@@ -62,8 +80,9 @@ func (a *App) logout(w http.ResponseWriter, r *http.Request) {
 
 func (a *App) refresh(w http.ResponseWriter, r *http.Request) {
 	uuid := gcontext.GetUUID(r.Context())
+	tokenType := gcontext.GetTokenType(r.Context())
 	userID := gcontext.GetUserID(r.Context())
-	at, rt, cerr := a.authSvc.Refresh(uuid, userID)
+	at, rt, cerr := a.authSvc.Refresh(uuid, tokenType, userID)
 	if cerr != nil {
 		http.Error(w, cerr.Error(), cerr.GetStatusCode())
 		return
@@ -79,6 +98,155 @@ func (a *App) refresh(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (a *App) listSessions(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	family := gcontext.GetFamily(r.Context())
+	sessions, cerr := a.authSvc.Sessions(userID, family)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) revokeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := gcontext.GetUserID(r.Context())
+	if cerr := a.authSvc.RevokeSession(userID, vars["id"]); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) logoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	if cerr := a.authSvc.LogoutAll(userID); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if cerr := a.pwdSvc.Forgot(req.Email, remoteIP(r)); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if cerr := a.pwdSvc.Reset(req.Token, req.Pass); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req magicLinkRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if cerr := a.magicSvc.Request(req.Email); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) magicLogin(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	res, cerr := a.magicSvc.Exchange(req.Token, newSession(r))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if cerr := a.verifySvc.Verify(req.Token); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) resendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	if cerr := a.verifySvc.Resend(userID); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//getJWKS publishes the public keys tokens can be verified with
+func (a *App) getJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(a.keyMgr.JWKS()); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+//newSession describes the client a session is started from
+func newSession(r *http.Request) *models.Session {
+	return &models.Session{
+		UserAgent: r.UserAgent(),
+		IP:        remoteIP(r),
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 func (a *App) withValidatedUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//unmarshal user
@@ -103,8 +271,44 @@ func (a *App) withValidatedUser(next http.Handler) http.Handler {
 	})
 }
 
-//withClaims is a middleware that parses and validates jwt, inserts token uuid and userID into request context
-func (a *App) withClaims(next http.Handler) http.Handler {
+//withAccessClaims is a middleware that only lets access tokens through, see withClaims
+func (a *App) withAccessClaims(next http.Handler) http.Handler {
+	return a.withClaims(services.AccessToken, next)
+}
+
+//withRefreshClaims is a middleware that only lets refresh tokens through, see withClaims
+func (a *App) withRefreshClaims(next http.Handler) http.Handler {
+	return a.withClaims(services.RefreshToken, next)
+}
+
+//withPermission is a middleware that only lets users whose roles grant perm through. It must run after withAccessClaims
+func (a *App) withPermission(perm string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !models.HasPermission(gcontext.GetRoles(r.Context()), perm) {
+				cerr := Cerr.NewForbidden("resource")
+				http.Error(w, cerr.Error(), cerr.GetStatusCode())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//rolesFromClaims extracts the roles claim, ignoring anything that isn't a string
+func rolesFromClaims(claims jwt.MapClaims) []string {
+	raw, _ := claims["roles"].([]interface{})
+	roles := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if role, ok := v.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+//withClaims is a middleware that parses and validates jwt of tokenType, inserts token uuid, type and userID into request context
+func (a *App) withClaims(tokenType string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//extract jwt from header
 		tokenString := r.Header.Get("Authorization")
@@ -122,14 +326,7 @@ func (a *App) withClaims(next http.Handler) http.Handler {
 		tokenString = splitToken[1]
 
 		//parse & validate jwt
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		token, err := jwt.Parse(tokenString, a.keyMgr.Keyfunc)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid token: %v", err)
 			http.Error(w, "", http.StatusUnauthorized)
@@ -151,17 +348,28 @@ func (a *App) withClaims(next http.Handler) http.Handler {
 			http.Error(w, "", http.StatusBadRequest)
 			return
 		}
-		//put uuid in context
+		//get token type from claims
+		typ, ok := claims["typ"].(string)
+		if !ok || typ != tokenType {
+			fmt.Fprintf(os.Stderr, "Expected %s token, got %q", tokenType, typ)
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		//put uuid, token type and roles in context. only access tokens carry roles
 		ctx := gcontext.WithUUID(r.Context(), uuid)
+		ctx = gcontext.WithTokenType(ctx, typ)
+		ctx = gcontext.WithRoles(ctx, rolesFromClaims(claims))
 
-		//get userID from tokenRepo storage. this is mainly for checking if access token is valid, i.e. still in storage
-		userID, cerr := a.authSvc.GetUserID(uuid)
+		//get token from tokenRepo storage. this is mainly for checking if the token is valid, i.e. still in storage
+		stored, cerr := a.authSvc.GetToken(uuid)
 		if cerr != nil {
 			http.Error(w, cerr.Error(), cerr.GetStatusCode())
 			return
 		}
-		//put userID in context
-		ctx = gcontext.WithUserID(ctx, userID)
+		//put userID and token family in context
+		ctx = gcontext.WithUserID(ctx, stored.UserID)
+		ctx = gcontext.WithFamily(ctx, stored.Family)
 
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"mmr/keys"
+	"mmr/models"
+	"mmr/repositories/memRepos"
+	"mmr/services"
+	"mmr/shared"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//newAuthTestApp returns an app with just what the auth middlewares and the handlers below them need,
+//along with the token pair of a freshly registered user
+func newAuthTestApp(t *testing.T) (*App, string, string) {
+	keyMgr, err := keys.NewManager(keys.RS256, services.MaxTokenTTL)
+	if err != nil {
+		t.Fatalf("new key manager: %v", err)
+	}
+	if err = keyMgr.Rotate(); err != nil {
+		t.Fatalf("rotate keys: %v", err)
+	}
+	box, err := shared.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+
+	usrRepo := memRepos.NewUser(make(map[int32]models.User), 1)
+	ottRepo := memRepos.NewOneTimeToken(make(map[string]map[string]models.OneTimeToken))
+	throttleSvc := services.NewThrottle(memRepos.NewThrottle(), shared.SystemClock, services.DefaultThrottleConfig)
+	tfSvc := services.NewTwoFactor(usrRepo, ottRepo, box, throttleSvc, shared.SystemClock, "test")
+	authSvc := services.NewAuth(usrRepo, memRepos.NewToken(make(map[string]models.Token)), keyMgr, tfSvc, throttleSvc)
+	lbRepo := memRepos.NewLeaderboard(make(map[int32]map[int32]float64))
+
+	a := &App{
+		usrSvc:    services.NewUser(usrRepo),
+		authSvc:   authSvc,
+		ratingSvc: services.NewRating(memRepos.NewRating(make(map[int32]map[int32]models.Rating)), lbRepo),
+		keyMgr:    keyMgr,
+	}
+
+	at, rt, cerr := authSvc.Register(&models.User{Email: "player@example.com", Pass: "secret123"}, &models.Session{})
+	if cerr != nil {
+		t.Fatalf("register: %v", cerr)
+	}
+
+	return a, at, rt
+}
+
+func serveWithToken(handler http.Handler, method, target, token string) int {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestRefreshRejectsAccessToken(t *testing.T) {
+	a, at, rt := newAuthTestApp(t)
+	handler := a.withRefreshClaims(http.HandlerFunc(a.refresh))
+
+	if code := serveWithToken(handler, "POST", "/auth/refresh", at); code != http.StatusUnauthorized {
+		t.Errorf("refresh with an access token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := serveWithToken(handler, "POST", "/auth/refresh", rt); code != http.StatusOK {
+		t.Errorf("refresh with a refresh token: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestAccessRoutesRejectRefreshToken(t *testing.T) {
+	a, at, rt := newAuthTestApp(t)
+	handler := a.withAccessClaims(http.HandlerFunc(a.getMe))
+
+	if code := serveWithToken(handler, "GET", "/users/me", rt); code != http.StatusUnauthorized {
+		t.Errorf("/users/me with a refresh token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := serveWithToken(handler, "GET", "/users/me", at); code != http.StatusOK {
+		t.Errorf("/users/me with an access token: got %d, want %d", code, http.StatusOK)
+	}
+}
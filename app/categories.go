@@ -4,20 +4,105 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	gcontext "mmr/context"
+	"mmr/models"
 	"net/http"
 	"os"
 	"strconv"
 )
 
+type categoryRequest struct {
+	Name        string   `json:"name" validate:"required,lte=64"`
+	Description string   `json:"description" validate:"lte=500"`
+	ParentID    *int32   `json:"parent_id"`
+	Tags        []string `json:"tags" validate:"lte=16,dive,lte=32"`
+	//models.DefaultMatchRules if left out
+	Rules *models.MatchRules `json:"rules"`
+	//by BCP 47 language tag
+	Translations map[string]models.CategoryTranslation `json:"translations" validate:"lte=32,dive"`
+}
+
+func (req *categoryRequest) category() *models.Category {
+	rules := models.DefaultMatchRules
+	if req.Rules != nil {
+		rules = *req.Rules
+	}
+
+	return &models.Category{
+		Name:         req.Name,
+		Description:  req.Description,
+		Translations: req.Translations,
+		ParentID:     req.ParentID,
+		Tags:         req.Tags,
+		Rules:        rules,
+	}
+}
+
+type reorderRequest struct {
+	Ids []int32 `json:"ids" validate:"required"`
+}
+
+//listCategories lists categories as a tree of subcategories with ?tree=true. Otherwise categories are
+//filtered by the q, tag, parent_id and ranked query parameters, ordered by sort and paginated by cursor and limit.
+//Names and descriptions are in the language of the Accept-Language header
 func (a *App) listCategories(w http.ResponseWriter, r *http.Request) {
-	ctgs, cerr := a.ctgSvc.List()
+	w.Header().Set("Vary", "Accept-Language")
+	acceptLanguage := r.Header.Get("Accept-Language")
+	query := r.URL.Query()
+	if tree, _ := strconv.ParseBool(query.Get("tree")); tree {
+		nodes, cerr := a.ctgSvc.Tree(acceptLanguage)
+		if cerr != nil {
+			http.Error(w, cerr.Error(), cerr.GetStatusCode())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(nodes); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+			http.Error(w, "", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	filter := &models.CategoryFilter{
+		Query: query.Get("q"),
+		Tag:   query.Get("tag"),
+		Sort:  query.Get("sort"),
+	}
+	if p := query.Get("parent_id"); p != "" {
+		parentID, err := strconv.ParseInt(p, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid parent_id", http.StatusBadRequest)
+			return
+		}
+		id := int32(parentID)
+		filter.ParentID = &id
+	}
+	if rk := query.Get("ranked"); rk != "" {
+		ranked, err := strconv.ParseBool(rk)
+		if err != nil {
+			http.Error(w, "Invalid ranked", http.StatusBadRequest)
+			return
+		}
+		filter.Ranked = &ranked
+	}
+	var limit int64
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, cerr := a.ctgSvc.List(filter, query.Get("cursor"), limit, acceptLanguage)
 	if cerr != nil {
 		http.Error(w, cerr.Error(), cerr.GetStatusCode())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err := json.NewEncoder(w).Encode(ctgs); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
 		http.Error(w, "", http.StatusInternalServerError)
 	}
@@ -31,14 +116,148 @@ func (a *App) getCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctg, cerr := a.ctgSvc.Get(int32(id))
+	w.Header().Set("Vary", "Accept-Language")
+	ctg, cerr := a.ctgSvc.Get(int32(id), r.Header.Get("Accept-Language"))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeCategory(w, ctg)
+}
+
+func (a *App) getLeaderboard(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var limit int64
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID := gcontext.GetUserID(r.Context())
+	page, cerr := a.lbSvc.Get(int32(id), userID, r.URL.Query().Get("cursor"), limit)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) listAllCategories(w http.ResponseWriter, r *http.Request) {
+	ctgs, cerr := a.ctgSvc.ListAll()
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeCategories(w, ctgs)
+}
+
+func (a *App) createCategory(w http.ResponseWriter, r *http.Request) {
+	var req categoryRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctg, cerr := a.ctgSvc.Create(req.category())
 	if cerr != nil {
 		http.Error(w, cerr.Error(), cerr.GetStatusCode())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err = json.NewEncoder(w).Encode(ctg); err != nil {
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(ctg); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+	}
+}
+
+func (a *App) updateCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req categoryRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctg, cerr := a.ctgSvc.Update(int32(id), req.category())
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeCategory(w, ctg)
+}
+
+func (a *App) archiveCategory(w http.ResponseWriter, r *http.Request) {
+	a.setArchived(w, r, true)
+}
+
+func (a *App) restoreCategory(w http.ResponseWriter, r *http.Request) {
+	a.setArchived(w, r, false)
+}
+
+func (a *App) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctg, cerr := a.ctgSvc.Archive(int32(id), archived)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeCategory(w, ctg)
+}
+
+func (a *App) reorderCategories(w http.ResponseWriter, r *http.Request) {
+	var req reorderRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctgs, cerr := a.ctgSvc.Reorder(req.Ids)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeCategories(w, ctgs)
+}
+
+func writeCategory(w http.ResponseWriter, ctg *models.Category) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(ctg); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func writeCategories(w http.ResponseWriter, ctgs []models.Category) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(ctgs); err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
 		http.Error(w, "", http.StatusInternalServerError)
 	}
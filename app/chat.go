@@ -0,0 +1,38 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	gcontext "mmr/context"
+	"net/http"
+	"os"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func (a *App) serveWs(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//upgrader has already replied with an http error
+		fmt.Fprintf(os.Stderr, "Unable to upgrade to websocket: %v\n", err)
+		return
+	}
+
+	a.hub.Serve(conn, userID)
+}
+
+//withQueryToken is a middleware that moves the token from the query string into the Authorization header,
+//since browsers can't set headers on websocket handshakes
+func (a *App) withQueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
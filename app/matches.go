@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	gcontext "mmr/context"
+	"mmr/models"
+	"mmr/shared"
+	"net/http"
+	"os"
+)
+
+type resultRequest struct {
+	Result string `json:"result" validate:"required,oneof=win loss draw"`
+}
+
+func (a *App) getMatch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	match, cerr := a.matchSvc.Get(vars["id"])
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeMatch(w, match)
+}
+
+func (a *App) getCurrentMatch(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	match, cerr := a.matchSvc.Current(userID)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeMatch(w, match)
+}
+
+func (a *App) reportResult(w http.ResponseWriter, r *http.Request) {
+	var req resultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid request: %v\n", err)
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(req); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err.(validator.ValidationErrors))
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID := gcontext.GetUserID(r.Context())
+	match, cerr := a.matchSvc.Report(vars["id"], userID, req.Result)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeMatch(w, match)
+}
+
+func (a *App) concede(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID := gcontext.GetUserID(r.Context())
+	match, cerr := a.matchSvc.Concede(vars["id"], userID)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	writeMatch(w, match)
+}
+
+func writeMatch(w http.ResponseWriter, match *models.Match) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(match); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
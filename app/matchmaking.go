@@ -0,0 +1,122 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	gcontext "mmr/context"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/shared"
+	"net/http"
+	"os"
+)
+
+//realtime message types of the matchmaking queue
+const (
+	msgQueueJoin   = "queue_join"
+	msgQueueLeave  = "queue_leave"
+	msgQueueStatus = "queue_status"
+)
+
+//joinQueueRequest asks to queue for a category, or for any category labeled tag
+type joinQueueRequest struct {
+	CategoryID int32  `json:"category_id" validate:"required_without=Tag"`
+	Tag        string `json:"tag" validate:"required_without=CategoryID"`
+}
+
+func (a *App) joinQueue(w http.ResponseWriter, r *http.Request) {
+	var req joinQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid request: %v\n", err)
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(req); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err.(validator.ValidationErrors))
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	userID := gcontext.GetUserID(r.Context())
+	entry, cerr := a.join(userID, req)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) leaveQueue(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	if cerr := a.mmSvc.Leave(userID); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) queueStatus(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	entry, cerr := a.mmSvc.Status(userID)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) wsJoinQueue(userID int32, payload json.RawMessage) {
+	var req joinQueueRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		a.hub.SendError(userID, "invalid request")
+		return
+	}
+	if err := shared.Validate.Struct(req); err != nil {
+		a.hub.SendError(userID, "invalid data")
+		return
+	}
+
+	entry, cerr := a.join(userID, req)
+	if cerr != nil {
+		a.hub.SendError(userID, cerr.Error())
+		return
+	}
+
+	_ = a.hub.Send(userID, msgQueueStatus, entry)
+}
+
+func (a *App) join(userID int32, req joinQueueRequest) (*models.QueueEntry, Cerr.CError) {
+	if req.CategoryID != 0 {
+		return a.mmSvc.Join(userID, req.CategoryID)
+	}
+
+	return a.mmSvc.JoinTag(userID, req.Tag)
+}
+
+func (a *App) wsLeaveQueue(userID int32, _ json.RawMessage) {
+	if cerr := a.mmSvc.Leave(userID); cerr != nil {
+		a.hub.SendError(userID, cerr.Error())
+	}
+}
+
+func (a *App) wsQueueStatus(userID int32, _ json.RawMessage) {
+	entry, cerr := a.mmSvc.Status(userID)
+	if cerr != nil {
+		a.hub.SendError(userID, cerr.Error())
+		return
+	}
+
+	_ = a.hub.Send(userID, msgQueueStatus, entry)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"mmr/services"
+	"net/http"
+	"os"
+)
+
+//oauthBindingCookie keeps the secret binding a login at a provider to the browser that started it
+const oauthBindingCookie = "oauth_binding"
+
+func (a *App) listProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string][]string{
+		"providers": a.oauthSvc.Providers(),
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+//startOAuth redirects the user to log in at the provider
+func (a *App) startOAuth(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	url, binding, cerr := a.oauthSvc.Start(vars["provider"])
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	setBindingCookie(w, r, binding, int(services.OAuthStateTTL.Seconds()))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+//oauthCallback is where the provider redirects the user back to with the authorization code
+func (a *App) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		fmt.Fprintf(os.Stderr, "Login at %s failed: %s %s\n", vars["provider"], e, query.Get("error_description"))
+		http.Error(w, "Login at the provider failed", http.StatusUnauthorized)
+		return
+	}
+	if query.Get("state") == "" || query.Get("code") == "" {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthBindingCookie)
+	if err != nil {
+		http.Error(w, "Invalid state", http.StatusUnauthorized)
+		return
+	}
+	setBindingCookie(w, r, "", -1)
+
+	res, cerr := a.oauthSvc.Callback(vars["provider"], query.Get("state"), cookie.Value, query.Get("code"),
+		newSession(r))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+//setBindingCookie sets the binding cookie for maxAge seconds, or removes it if maxAge is negative.
+//It has to be sent along when the provider redirects back, which SameSite lax allows
+func setBindingCookie(w http.ResponseWriter, r *http.Request, binding string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthBindingCookie,
+		Value:    binding,
+		Path:     "/auth/oauth",
+		MaxAge:   maxAge,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
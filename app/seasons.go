@@ -0,0 +1,75 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	gcontext "mmr/context"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+func (a *App) listSeasons(w http.ResponseWriter, r *http.Request) {
+	seasons, cerr := a.seasonSvc.List()
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(seasons); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) getCurrentSeason(w http.ResponseWriter, r *http.Request) {
+	season, cerr := a.seasonSvc.Current()
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(season); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) getStandings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	categoryID, err := strconv.ParseInt(query.Get("category_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid category_id", http.StatusBadRequest)
+		return
+	}
+	var limit int64
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID := gcontext.GetUserID(r.Context())
+	page, cerr := a.seasonSvc.Standings(int32(id), int32(categoryID), userID, query.Get("cursor"), limit)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err = json.NewEncoder(w).Encode(page); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	gcontext "mmr/context"
+	"net/http"
+	"os"
+)
+
+type codeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type completeLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+func (a *App) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	enrollment, cerr := a.tfSvc.Enroll(userID)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req codeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID := gcontext.GetUserID(r.Context())
+	codes, cerr := a.tfSvc.Confirm(userID, req.Code)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string][]string{
+		"recovery_codes": codes,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req codeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	userID := gcontext.GetUserID(r.Context())
+	if cerr := a.tfSvc.Disable(userID, req.Code); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) completeLogin(w http.ResponseWriter, r *http.Request) {
+	var req completeLoginRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	at, rt, cerr := a.authSvc.CompleteLogin(req.ChallengeToken, req.Code, newSession(r))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"access_token":  at,
+		"refresh_token": rt,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
@@ -3,11 +3,34 @@ package app
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/gorilla/mux"
 	gcontext "mmr/context"
+	"mmr/models"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
+type meResponse struct {
+	*models.User
+	Ratings []models.Rating `json:"ratings"`
+}
+
+type profileRequest struct {
+	Name string `json:"name" validate:"lte=20"`
+}
+
+type changePasswordRequest struct {
+	CurrentPass string `json:"current_pass" validate:"required"`
+	Pass        string `json:"pass" validate:"required,gte=6"`
+}
+
+type changeEmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Pass  string `json:"pass" validate:"required"`
+}
+
 func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
 	userID := gcontext.GetUserID(r.Context())
 	dbUsr, cerr := a.usrSvc.Find(userID)
@@ -16,10 +39,170 @@ func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ratings, cerr := a.ratingSvc.List(userID)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if err := json.NewEncoder(w).Encode(&dbUsr); err != nil {
+	if err := json.NewEncoder(w).Encode(&meResponse{User: dbUsr, Ratings: ratings}); err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 }
+
+func (a *App) updateMe(w http.ResponseWriter, r *http.Request) {
+	var req profileRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	dbUsr, cerr := a.usrSvc.UpdateProfile(gcontext.GetUserID(r.Context()), &models.User{Name: req.Name})
+	if cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(dbUsr); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+//changePassword keeps the session the request is made from, every other session of the user ends
+func (a *App) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ctx := r.Context()
+	if cerr := a.pwdSvc.Change(gcontext.GetUserID(ctx), req.CurrentPass, req.Pass, gcontext.GetFamily(ctx)); cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) changeEmail(w http.ResponseWriter, r *http.Request) {
+	var req changeEmailRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	dbUsr, cerr := a.verifySvc.ChangeEmail(gcontext.GetUserID(r.Context()), req.Email, req.Pass)
+	if cerr != nil {
+		writeError(w, cerr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(dbUsr); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) getUserRatings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//make sure the user exists, so that unknown ids aren't answered with an empty list
+	if _, cerr := a.usrSvc.Find(int32(id)); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	ratings, cerr := a.ratingSvc.List(int32(id))
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err = json.NewEncoder(w).Encode(ratings); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (a *App) getMyMatches(w http.ResponseWriter, r *http.Request) {
+	userID := gcontext.GetUserID(r.Context())
+	a.writeMatchHistory(w, r, userID)
+}
+
+func (a *App) getUserMatches(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, cerr := a.usrSvc.Find(int32(id)); cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	a.writeMatchHistory(w, r, int32(id))
+}
+
+//writeMatchHistory replies with the match history of userID filtered by the
+//category_id, from and to (RFC 3339) query parameters and paginated by cursor and limit
+func (a *App) writeMatchHistory(w http.ResponseWriter, r *http.Request, userID int32) {
+	query := r.URL.Query()
+	filter := &models.MatchFilter{}
+	if c := query.Get("category_id"); c != "" {
+		categoryID, err := strconv.ParseInt(c, 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid category_id", http.StatusBadRequest)
+			return
+		}
+		id := int32(categoryID)
+		filter.CategoryID = &id
+	}
+	if f := query.Get("from"); f != "" {
+		from, err := time.Parse(time.RFC3339, f)
+		if err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		filter.From = from
+	}
+	if t := query.Get("to"); t != "" {
+		to, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			http.Error(w, "Invalid to", http.StatusBadRequest)
+			return
+		}
+		filter.To = to
+	}
+	var limit int64
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, cerr := a.matchSvc.History(userID, filter, query.Get("cursor"), limit)
+	if cerr != nil {
+		http.Error(w, cerr.Error(), cerr.GetStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode json: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+}
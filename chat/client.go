@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"os"
+	"time"
+)
+
+const (
+	//time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	//time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+	//send pings to peer with this period. Must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	//maximum message size allowed from peer
+	maxMessageSize = 4096
+	//number of outgoing messages buffered per connection
+	sendBufferSize = 64
+)
+
+//Client is a single websocket connection of an authenticated user
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID int32
+	send   chan []byte
+}
+
+func newClient(hub *Hub, conn *websocket.Conn, userID int32) *Client {
+	return &Client{
+		hub:    hub,
+		conn:   conn,
+		userID: userID,
+		send:   make(chan []byte, sendBufferSize),
+	}
+}
+
+//readPump reads messages from the connection and dispatches them to the hub.
+//There is at most one reader per connection, so all reads are done from this goroutine
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				fmt.Fprintf(os.Stderr, "Unexpected websocket close for user %d: %v\n", c.userID, err)
+			}
+			return
+		}
+
+		var msg Message
+		if err = json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			c.hub.SendError(c.userID, "invalid message")
+			continue
+		}
+
+		c.hub.dispatch(c.userID, &msg)
+	}
+}
+
+//writePump writes queued messages and pings to the connection.
+//There is at most one writer per connection, so all writes are done from this goroutine
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				//the hub closed the channel
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't write websocket message for user %d: %v\n", c.userID, err)
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
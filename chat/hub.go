@@ -0,0 +1,241 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/shared"
+	"os"
+	"sync"
+)
+
+//HandlerFunc handles a message of a registered type received from userID
+type HandlerFunc func(userID int32, payload json.RawMessage)
+
+//HookFunc is called when userID connects or disconnects
+type HookFunc func(userID int32)
+
+//AllowFunc reports whether userID may send a chat message of kind
+type AllowFunc func(userID int32, kind string) bool
+
+//Hub keeps track of connected users and of which users are paired with each other
+type Hub struct {
+	clients      map[int32]*Client
+	peers        map[int32]int32
+	handlers     map[string]HandlerFunc
+	onConnect    []HookFunc
+	onDisconnect []HookFunc
+	allow        []AllowFunc
+	mu           sync.Mutex
+}
+
+func NewHub() *Hub {
+	h := &Hub{
+		clients:  make(map[int32]*Client),
+		peers:    make(map[int32]int32),
+		handlers: make(map[string]HandlerFunc),
+		mu:       sync.Mutex{},
+	}
+	h.handlers[TypeChat] = h.handleChat
+
+	return h
+}
+
+//Handle registers fn as the handler for incoming messages of msgType
+func (h *Hub) Handle(msgType string, fn HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.handlers[msgType] = fn
+}
+
+//OnConnect registers fn to be called every time a user connects
+func (h *Hub) OnConnect(fn HookFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onConnect = append(h.onConnect, fn)
+}
+
+//OnDisconnect registers fn to be called every time the last connection of a user is closed
+func (h *Hub) OnDisconnect(fn HookFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.onDisconnect = append(h.onDisconnect, fn)
+}
+
+//Allow registers fn to be asked before a chat message is delivered, every fn must allow it
+func (h *Hub) Allow(fn AllowFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.allow = append(h.allow, fn)
+}
+
+//Serve registers the connection of userID and starts its pumps. A previous connection of the same user is closed
+func (h *Hub) Serve(conn *websocket.Conn, userID int32) {
+	c := newClient(h, conn, userID)
+
+	h.mu.Lock()
+	if old, ok := h.clients[userID]; ok {
+		close(old.send)
+	}
+	h.clients[userID] = c
+	hooks := h.onConnect
+	h.mu.Unlock()
+
+	go c.writePump()
+	go c.readPump()
+
+	for _, fn := range hooks {
+		fn(userID)
+	}
+}
+
+//Connected reports whether userID has an open connection
+func (h *Hub) Connected(userID int32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, ok := h.clients[userID]
+	return ok
+}
+
+//Pair connects two users so that chat messages of one are delivered to the other
+func (h *Hub) Pair(a, b int32) {
+	h.mu.Lock()
+	h.unpair(a)
+	h.unpair(b)
+	h.peers[a] = b
+	h.peers[b] = a
+	h.mu.Unlock()
+
+	_ = h.Send(a, TypePaired, pairedPayload{PeerID: b})
+	_ = h.Send(b, TypePaired, pairedPayload{PeerID: a})
+}
+
+//Unpair disconnects userID from its peer, if it has one
+func (h *Hub) Unpair(userID int32) {
+	h.mu.Lock()
+	peerID, ok := h.unpair(userID)
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	_ = h.Send(userID, TypeUnpaired, pairedPayload{PeerID: peerID})
+	_ = h.Send(peerID, TypeUnpaired, pairedPayload{PeerID: userID})
+}
+
+//Send delivers a message to userID if the user is connected
+func (h *Hub) Send(userID int32, msgType string, payload interface{}) Cerr.CError {
+	data, err := newMessage(msgType, payload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode websocket message: %v\n", err)
+		return Cerr.NewInternal()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.clients[userID]
+	if !ok {
+		return Cerr.NewNotFound("connection")
+	}
+
+	select {
+	case c.send <- data:
+	default:
+		//the client can't keep up, drop it. readPump will fail and unregister the client
+		fmt.Fprintf(os.Stderr, "Send buffer full for user %d, closing connection\n", userID)
+		_ = c.conn.Close()
+	}
+
+	return nil
+}
+
+//SendError delivers an error message to userID if the user is connected
+func (h *Hub) SendError(userID int32, text string) {
+	_ = h.Send(userID, TypeError, errorPayload{Error: text})
+}
+
+//unpair must be called with the lock held
+func (h *Hub) unpair(userID int32) (int32, bool) {
+	peerID, ok := h.peers[userID]
+	if !ok {
+		return 0, false
+	}
+
+	delete(h.peers, userID)
+	delete(h.peers, peerID)
+	return peerID, true
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	//the connection might have already been replaced by a newer one
+	cur, ok := h.clients[c.userID]
+	if !ok || cur != c {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, c.userID)
+	close(c.send)
+	hooks := h.onDisconnect
+	h.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(c.userID)
+	}
+}
+
+func (h *Hub) dispatch(userID int32, msg *Message) {
+	h.mu.Lock()
+	fn, ok := h.handlers[msg.Type]
+	h.mu.Unlock()
+	if !ok {
+		h.SendError(userID, fmt.Sprintf("unknown message type %s", msg.Type))
+		return
+	}
+
+	fn(userID, msg.Payload)
+}
+
+func (h *Hub) handleChat(userID int32, payload json.RawMessage) {
+	var p chatPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		h.SendError(userID, "invalid chat message")
+		return
+	}
+	if err := shared.Validate.Struct(p); err != nil {
+		h.SendError(userID, "invalid chat message")
+		return
+	}
+
+	if p.Kind == "" {
+		p.Kind = models.MessageText
+	}
+
+	h.mu.Lock()
+	peerID, ok := h.peers[userID]
+	allow := h.allow
+	h.mu.Unlock()
+	if !ok {
+		h.SendError(userID, "not paired")
+		return
+	}
+	for _, fn := range allow {
+		if !fn(userID, p.Kind) {
+			h.SendError(userID, fmt.Sprintf("%s messages are not allowed", p.Kind))
+			return
+		}
+	}
+
+	p.From = userID
+	if cerr := h.Send(peerID, TypeChat, p); cerr != nil {
+		h.SendError(userID, "peer is not connected")
+	}
+}
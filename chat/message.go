@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"encoding/json"
+)
+
+//message types that can travel over the websocket connection
+const (
+	TypeChat     = "chat"
+	TypeError    = "error"
+	TypePaired   = "paired"
+	TypeUnpaired = "unpaired"
+)
+
+//Message is the envelope for everything sent over the websocket connection. Payload is decoded by the handler for Type
+type Message struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type chatPayload struct {
+	From int32 `json:"from,omitempty"`
+	//one of the models.Message kinds, text if left out
+	Kind string `json:"kind" validate:"omitempty,oneof=text emoji image"`
+	Text string `json:"text" validate:"required,lte=1000"`
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+type pairedPayload struct {
+	PeerID int32 `json:"peer_id"`
+}
+
+func newMessage(msgType string, payload interface{}) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(Message{
+		Type:    msgType,
+		Payload: raw,
+	})
+}
@@ -12,9 +12,12 @@ func (c contextKey) String() string {
 }
 
 const (
-	uuidKey   = contextKey("uuid")
-	userIDKey = contextKey("user_id")
-	userKey   = contextKey("user")
+	uuidKey      = contextKey("uuid")
+	userIDKey    = contextKey("user_id")
+	userKey      = contextKey("user")
+	tokenTypeKey = contextKey("token_type")
+	familyKey    = contextKey("family")
+	rolesKey     = contextKey("roles")
 )
 
 func GetUserID(ctx context.Context) int32 {
@@ -43,3 +46,30 @@ func GetUUID(ctx context.Context) string {
 func WithUUID(ctx context.Context, uuid string) context.Context {
 	return context.WithValue(ctx, uuidKey, uuid)
 }
+
+func GetTokenType(ctx context.Context) string {
+	typ, _ := ctx.Value(tokenTypeKey).(string)
+	return typ
+}
+
+func WithTokenType(ctx context.Context, tokenType string) context.Context {
+	return context.WithValue(ctx, tokenTypeKey, tokenType)
+}
+
+func GetFamily(ctx context.Context) string {
+	family, _ := ctx.Value(familyKey).(string)
+	return family
+}
+
+func WithFamily(ctx context.Context, family string) context.Context {
+	return context.WithValue(ctx, familyKey, family)
+}
+
+func GetRoles(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey).([]string)
+	return roles
+}
+
+func WithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesKey, roles)
+}
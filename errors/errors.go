@@ -2,7 +2,9 @@ package errors
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"time"
 )
 
 type CError interface {
@@ -10,6 +12,11 @@ type CError interface {
 	Error() string
 }
 
+//Retryable is implemented by errors that tell the client when to retry
+type Retryable interface {
+	GetRetryAfter() time.Duration
+}
+
 type Exists struct {
 	StatusCode int
 	Field      string
@@ -79,3 +86,78 @@ func NewUnauthorized(field string) Unauthorized {
 		Field:      field,
 	}
 }
+
+type Conflict struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e Conflict) GetStatusCode() int {
+	return e.StatusCode
+}
+func (e Conflict) Error() string {
+	return e.Reason
+}
+func NewConflict(reason string) Conflict {
+	return Conflict{
+		StatusCode: http.StatusConflict,
+		Reason:     reason,
+	}
+}
+
+type Forbidden struct {
+	StatusCode int
+	Field      string
+}
+
+func (e Forbidden) GetStatusCode() int {
+	return e.StatusCode
+}
+func (e Forbidden) Error() string {
+	return fmt.Sprintf("You don't have access to this %s", e.Field)
+}
+func NewForbidden(field string) Forbidden {
+	return Forbidden{
+		StatusCode: http.StatusForbidden,
+		Field:      field,
+	}
+}
+
+type BadRequest struct {
+	StatusCode int
+	Field      string
+}
+
+func (e BadRequest) GetStatusCode() int {
+	return e.StatusCode
+}
+func (e BadRequest) Error() string {
+	return fmt.Sprintf("Invalid %s", e.Field)
+}
+func NewBadRequest(field string) BadRequest {
+	return BadRequest{
+		StatusCode: http.StatusBadRequest,
+		Field:      field,
+	}
+}
+
+type TooManyRequests struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e TooManyRequests) GetStatusCode() int {
+	return e.StatusCode
+}
+func (e TooManyRequests) GetRetryAfter() time.Duration {
+	return e.RetryAfter
+}
+func (e TooManyRequests) Error() string {
+	return fmt.Sprintf("Too many attempts, retry in %.0f seconds", math.Ceil(e.RetryAfter.Seconds()))
+}
+func NewTooManyRequests(retryAfter time.Duration) TooManyRequests {
+	return TooManyRequests{
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+	}
+}
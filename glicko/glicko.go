@@ -0,0 +1,128 @@
+//Package glicko implements the Glicko-2 rating system as described in http://www.glicko.net/glicko/glicko2.pdf
+package glicko
+
+import "math"
+
+const (
+	DefaultRating     = 1500.0
+	DefaultDeviation  = 350.0
+	DefaultVolatility = 0.06
+
+	//tau constrains the change in volatility over time
+	tau = 0.5
+	//scale converts between the Glicko and the Glicko-2 scale
+	scale = 173.7178
+	//convergence tolerance of the volatility iteration
+	epsilon = 0.000001
+)
+
+//Score of a single game from the point of view of the rated player
+const (
+	Loss = 0.0
+	Draw = 0.5
+	Win  = 1.0
+)
+
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+type Result struct {
+	Opponent Rating
+	Score    float64
+}
+
+func Default() Rating {
+	return Rating{
+		Rating:     DefaultRating,
+		Deviation:  DefaultDeviation,
+		Volatility: DefaultVolatility,
+	}
+}
+
+//Update returns the rating of r after a rating period in which the given games were played.
+//A period without games only increases the deviation
+func Update(r Rating, results ...Result) Rating {
+	mu := (r.Rating - DefaultRating) / scale
+	phi := r.Deviation / scale
+
+	if len(results) == 0 {
+		return Rating{
+			Rating:     r.Rating,
+			Deviation:  math.Min(math.Sqrt(phi*phi+r.Volatility*r.Volatility)*scale, DefaultDeviation),
+			Volatility: r.Volatility,
+		}
+	}
+
+	//estimated variance and improvement
+	var vInv, sum float64
+	for _, res := range results {
+		muJ := (res.Opponent.Rating - DefaultRating) / scale
+		phiJ := res.Opponent.Deviation / scale
+		gJ := g(phiJ)
+		e := expected(mu, muJ, gJ)
+
+		vInv += gJ * gJ * e * (1 - e)
+		sum += gJ * (res.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma := volatility(phi, r.Volatility, v, delta)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phiNew := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	muNew := mu + phiNew*phiNew*sum
+
+	return Rating{
+		Rating:     muNew*scale + DefaultRating,
+		Deviation:  math.Min(phiNew*scale, DefaultDeviation),
+		Volatility: sigma,
+	}
+}
+
+func g(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func expected(mu, muJ, gJ float64) float64 {
+	return 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+}
+
+//volatility finds the new volatility with the Illinois algorithm (step 5 of the paper)
+func volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,92 @@
+package glicko
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+//TestUpdatePaperExample checks the worked example of section 3 of the Glicko-2 paper
+func TestUpdatePaperExample(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := Update(player,
+		Result{Opponent: Rating{Rating: 1400, Deviation: 30, Volatility: DefaultVolatility}, Score: Win},
+		Result{Opponent: Rating{Rating: 1550, Deviation: 100, Volatility: DefaultVolatility}, Score: Loss},
+		Result{Opponent: Rating{Rating: 1700, Deviation: 300, Volatility: DefaultVolatility}, Score: Loss},
+	)
+
+	if !near(got.Rating, 1464.06, 0.01) {
+		t.Errorf("rating = %v, want 1464.06", got.Rating)
+	}
+	if !near(got.Deviation, 151.52, 0.01) {
+		t.Errorf("deviation = %v, want 151.52", got.Deviation)
+	}
+	if !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("volatility = %v, want 0.05999", got.Volatility)
+	}
+}
+
+func TestUpdateWithoutGames(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Rating
+		want float64
+	}{
+		//phi* = sqrt(phi^2 + sigma^2), step 6 of the paper
+		{"settled", Rating{Rating: 1700, Deviation: 50, Volatility: 0.06}, math.Sqrt(50*50 + math.Pow(0.06*scale, 2))},
+		{"volatile", Rating{Rating: 1300, Deviation: 200, Volatility: 0.2}, math.Sqrt(200*200 + math.Pow(0.2*scale, 2))},
+		{"capped", Rating{Rating: 1500, Deviation: 340, Volatility: 0.5}, DefaultDeviation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Update(tt.r)
+			if !near(got.Deviation, tt.want, 1e-9) {
+				t.Errorf("deviation = %v, want %v", got.Deviation, tt.want)
+			}
+			if got.Deviation <= tt.r.Deviation && tt.r.Deviation < DefaultDeviation {
+				t.Errorf("deviation didn't increase from %v", tt.r.Deviation)
+			}
+			if got.Rating != tt.r.Rating || got.Volatility != tt.r.Volatility {
+				t.Errorf("got %+v, want rating and volatility of %+v", got, tt.r)
+			}
+		})
+	}
+}
+
+//TestVolatilityConverges checks that the iteration ends at the root of f of step 5 of the paper,
+//starting from either bracket the paper gives
+func TestVolatilityConverges(t *testing.T) {
+	tests := []struct {
+		name                 string
+		phi, sigma, v, delta float64
+	}{
+		{"paper example", 200 / scale, 0.06, 1.7785, -0.4834},
+		{"upset", 50 / scale, 0.06, 0.5, 3},
+		{"expected result", 350 / scale, 0.06, 10, 0.01},
+		{"high volatility", 100 / scale, 0.5, 2, -1},
+		{"low volatility", 30 / scale, 0.001, 1, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := volatility(tt.phi, tt.sigma, tt.v, tt.delta)
+			if math.IsNaN(got) || got <= 0 {
+				t.Fatalf("volatility = %v", got)
+			}
+
+			a := math.Log(tt.sigma * tt.sigma)
+			f := func(x float64) float64 {
+				ex := math.Exp(x)
+				d := tt.phi*tt.phi + tt.v + ex
+				return ex*(tt.delta*tt.delta-tt.phi*tt.phi-tt.v-ex)/(2*d*d) - (x-a)/(tau*tau)
+			}
+			//the root is bracketed within epsilon of the result
+			x := math.Log(got * got)
+			if f(x-2*epsilon)*f(x+2*epsilon) > 0 {
+				t.Errorf("volatility = %v isn't a root of f, f(x) = %v", got, f(x))
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+//Package keys manages the asymmetric keys that sign and verify jwts, and publishes the public ones as a JWK set
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+//supported signing algorithms
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const rsaBits = 2048
+
+type Key struct {
+	ID      string
+	Alg     string
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	//zero while the key signs tokens. Keys retired by a rotation only verify tokens until then
+	ExpiresAt time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type Manager struct {
+	alg string
+	//how long a retired key keeps verifying tokens, at least the lifetime of the longest lived token
+	retention time.Duration
+	signing   *Key
+	keys      map[string]*Key
+	mu        sync.RWMutex
+}
+
+func NewManager(alg string, retention time.Duration) (*Manager, error) {
+	if alg != RS256 && alg != EdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	return &Manager{
+		alg:       alg,
+		retention: retention,
+		keys:      make(map[string]*Key),
+		mu:        sync.RWMutex{},
+	}, nil
+}
+
+//LoadPEM adds the PKCS #8 or PKCS #1 private keys of data, a key set of one or more PEM blocks.
+//The first key becomes the signing key, its algorithm derived from the key type. The others only verify tokens for
+//the retention period, so that the tokens signed by keys replaced in the set keep working until they expire
+func (m *Manager) LoadPEM(data []byte) error {
+	var set []*Key
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		key, err := parseKey(block)
+		if err != nil {
+			return fmt.Errorf("key %d: %v", len(set)+1, err)
+		}
+		set = append(set, key)
+	}
+	if len(set) == 0 {
+		return errors.New("no PEM block found")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.alg = set[0].Alg
+	m.promote(set[0])
+	expiresAt := time.Now().Add(m.retention)
+	for _, key := range set[1:] {
+		if _, ok := m.keys[key.ID]; ok {
+			continue
+		}
+		key.ExpiresAt = expiresAt
+		m.keys[key.ID] = key
+	}
+	return nil
+}
+
+func parseKey(block *pem.Block) (*Key, error) {
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %v", err)
+		}
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return newKey(RS256, k, &k.PublicKey)
+	case ed25519.PrivateKey:
+		return newKey(EdDSA, k, k.Public())
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+//Rotate generates a new signing key. The previous one keeps verifying tokens for the retention period
+func (m *Manager) Rotate() error {
+	m.mu.RLock()
+	alg := m.alg
+	m.mu.RUnlock()
+
+	var key *Key
+	var err error
+	switch alg {
+	case RS256:
+		var k *rsa.PrivateKey
+		if k, err = rsa.GenerateKey(rand.Reader, rsaBits); err == nil {
+			key, err = newKey(RS256, k, &k.PublicKey)
+		}
+	case EdDSA:
+		var pub ed25519.PublicKey
+		var k ed25519.PrivateKey
+		if pub, k, err = ed25519.GenerateKey(rand.Reader); err == nil {
+			key, err = newKey(EdDSA, k, pub)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to generate %s key: %v", alg, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.promote(key)
+	return nil
+}
+
+//Prune forgets retired keys whose retention period is over
+func (m *Manager) Prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, key := range m.keys {
+		if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+			delete(m.keys, id)
+		}
+	}
+}
+
+//Run rotates the signing key every interval and prunes expired keys until stop is closed
+func (m *Manager) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't rotate signing key: %v\n", err)
+			}
+			m.Prune()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Sign returns the signed jwt of claims, with the kid header set to the signing key
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.signing
+	m.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("no signing key")
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+//Keyfunc looks up the verification key of a token by its kid header, to be used with jwt.Parse
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing kid header")
+	}
+
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	m.mu.RUnlock()
+	if !ok || (!key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt)) {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	//don't let the token pick the algorithm
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+//JWKS returns the public keys that currently verify tokens
+func (m *Manager) JWKS() *JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := &JWKSet{Keys: make([]JWK, 0, len(m.keys))}
+	now := time.Now()
+	for _, key := range m.keys {
+		if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+			continue
+		}
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	return set
+}
+
+//promote must be called with the lock held
+func (m *Manager) promote(key *Key) {
+	if m.signing != nil && m.signing.ID != key.ID {
+		m.signing.ExpiresAt = time.Now().Add(m.retention)
+	}
+	m.signing = key
+	m.keys[key.ID] = key
+}
+
+func newKey(alg string, private crypto.PrivateKey, public crypto.PublicKey) (*Key, error) {
+	key := &Key{
+		Alg:     alg,
+		private: private,
+		public:  public,
+	}
+
+	id, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+
+	return key, nil
+}
+
+func (k *Key) jwk() JWK {
+	jwk := JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Alg,
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+//thumbprint computes the RFC 7638 thumbprint of the key, so that every instance loading the same key agrees on its id
+func (k *Key) thumbprint() (string, error) {
+	jwk := k.jwk()
+
+	//members must be in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %T", k.public)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
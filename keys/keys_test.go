@@ -0,0 +1,90 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt"
+	"testing"
+	"time"
+)
+
+func pemKey(t *testing.T, private interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestLoadPEMKeySet(t *testing.T) {
+	_, current, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//a token signed before the replaced key was moved behind the current one
+	old, err := NewManager(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = old.LoadPEM(pemKey(t, replaced)); err != nil {
+		t.Fatalf("load replaced key: %v", err)
+	}
+	oldToken, err := old.Sign(jwt.StandardClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.LoadPEM(append(pemKey(t, current), pemKey(t, replaced)...)); err != nil {
+		t.Fatalf("load key set: %v", err)
+	}
+
+	token, err := m.Sign(jwt.StandardClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := jwt.Parse(token, m.Keyfunc)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if parsed.Method.Alg() != EdDSA {
+		t.Errorf("signed with %s, want the first key of the set", parsed.Method.Alg())
+	}
+	if _, err = jwt.Parse(oldToken, m.Keyfunc); err != nil {
+		t.Errorf("token of the replaced key doesn't verify: %v", err)
+	}
+	if got := len(m.JWKS().Keys); got != 2 {
+		t.Errorf("JWKS has %d keys, want 2", got)
+	}
+
+	for _, key := range m.keys {
+		if key.ID == m.signing.ID {
+			continue
+		}
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(time.Now().Add(time.Hour)) {
+			t.Errorf("replaced key expires at %v, want within the retention period", key.ExpiresAt)
+		}
+	}
+}
+
+func TestLoadPEMWithoutKeys(t *testing.T) {
+	m, err := NewManager(RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.LoadPEM([]byte("not a key")); err == nil {
+		t.Error("loaded a key set without keys")
+	}
+}
@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//File writes every message to its own .eml file in a directory, for local testing
+type File struct {
+	dir string
+	seq uint64
+}
+
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &File{
+		dir: dir,
+	}, nil
+}
+
+func (f *File) Send(to, subject, body string) error {
+	msg := Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+		SentAt:  time.Now(),
+	}
+
+	name := fmt.Sprintf("%s-%d-%s.eml", msg.SentAt.Format("20060102T150405"), atomic.AddUint64(&f.seq, 1),
+		strings.NewReplacer("@", "_at_", "/", "_", string(filepath.Separator), "_").Replace(to))
+
+	return os.WriteFile(filepath.Join(f.dir, name), msg.format(""), 0o644)
+}
@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+//Memory keeps sent messages in memory, for local testing
+type Memory struct {
+	messages []Message
+	//every message is also written to out if set
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewMemory(out io.Writer) *Memory {
+	return &Memory{
+		out: out,
+		mu:  sync.Mutex{},
+	}
+}
+
+func (m *Memory) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+		SentAt:  time.Now(),
+	}
+	m.messages = append(m.messages, msg)
+
+	if m.out != nil {
+		if _, err := m.out.Write(append(msg.format(""), '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Messages returns the messages sent to the address, oldest first
+func (m *Memory) Messages(to string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []Message
+	for _, msg := range m.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"time"
+)
+
+//SMTP delivers messages through an SMTP server, authenticating with PLAIN if a username is set
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	s := &SMTP{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return s
+}
+
+func (s *SMTP) Send(to, subject, body string) error {
+	msg := Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+		SentAt:  time.Now(),
+	}
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, msg.format(s.from))
+}
@@ -1,21 +1,228 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/text/language"
+	"log"
 	"mmr/app"
+	"mmr/chat"
+	Cerr "mmr/errors"
+	"mmr/keys"
+	"mmr/mail"
 	"mmr/models"
+	"mmr/oauth"
 	"mmr/repositories/memRepos"
 	"mmr/services"
+	"mmr/shared"
+	"os"
+	"time"
 )
 
 func main() {
 	//init services
 	usrRepo := memRepos.NewUser(make(map[int32]models.User), 0)
 	usrSvc := services.NewUser(usrRepo)
-	ctgRepo := memRepos.NewCategory(make(map[int32]models.Category))
-	ctgSvc := services.NewCategory(ctgRepo)
-	tokenRepo := memRepos.NewToken(make(map[string]int32))
-	authSvc := services.NewAuth(usrRepo, tokenRepo)
+	seedAdmin(usrRepo)
+	ctgRepo := memRepos.NewCategory(make(map[int32]memRepos.StoredCategory), 1)
+	ctgSvc := services.NewCategory(ctgRepo, defaultLocale())
+	tokenRepo := memRepos.NewToken(make(map[string]models.Token))
+	keyMgr := newKeyManager()
+	ottRepo := memRepos.NewOneTimeToken(make(map[string]map[string]models.OneTimeToken))
+	throttleSvc := services.NewThrottle(memRepos.NewThrottle(), shared.SystemClock, services.DefaultThrottleConfig)
+	tfSvc := services.NewTwoFactor(usrRepo, ottRepo, newSecretBox(), throttleSvc, shared.SystemClock,
+		"Competitive Chatroulette")
+	authSvc := services.NewAuth(usrRepo, tokenRepo, keyMgr, tfSvc, throttleSvc)
+	mailer := newMailer()
+	pwdSvc := services.NewPassword(usrRepo, ottRepo, tokenRepo, throttleSvc, mailer, appURL())
+	verifySvc := services.NewVerification(usrRepo, ottRepo, throttleSvc, mailer, appURL())
+	authSvc.OnRegister(verifySvc.Registered)
+	magicSvc := services.NewMagicLink(authSvc, ottRepo, throttleSvc, mailer, appURL())
+	oauthSvc := services.NewOAuth(authSvc, memRepos.NewIdentity(), ottRepo, apiURL()+"/auth/oauth", newProviders()...)
 
-	a := app.NewApp(usrSvc, ctgSvc, authSvc)
+	hub := chat.NewHub()
+	lbRepo := memRepos.NewLeaderboard(make(map[int32]map[int32]float64))
+	lbSvc := services.NewLeaderboard(lbRepo, ctgRepo)
+	ratingRepo := memRepos.NewRating(make(map[int32]map[int32]models.Rating))
+	ratingSvc := services.NewRating(ratingRepo, lbRepo)
+	seasonRepo := memRepos.NewSeason(make(map[int32]models.Season), make(map[int32][]models.SeasonStanding), 1)
+	seasonSvc := services.NewSeason(seasonRepo, ratingSvc, lbSvc, shared.SystemClock, services.DefaultSeasonConfig)
+	go seasonSvc.Run(time.Minute, make(chan struct{}))
+	matchRepo := memRepos.NewMatch(make(map[string]models.Match))
+	matchSvc := services.NewMatch(matchRepo, ratingSvc, hub, shared.SystemClock, 30*time.Second)
+	queueRepo := memRepos.NewQueue(make(map[int32]models.QueueEntry))
+	mmSvc := services.NewMatchmaking(queueRepo, ctgRepo, ratingSvc, matchSvc, hub, shared.SystemClock,
+		services.DefaultMatchmakingConfig)
+	mmSvc.RequireRanked(verifySvc.RequireVerified)
+	ctgSvc.StatsFrom(mmSvc.Stats)
+	go mmSvc.Run(time.Second, make(chan struct{}))
+
+	a := app.NewApp(usrSvc, ctgSvc, authSvc, mmSvc, ratingSvc, matchSvc, lbSvc, seasonSvc, hub, keyMgr, pwdSvc, verifySvc,
+		tfSvc, oauthSvc, magicSvc)
 	a.Run()
 }
+
+//seedAdmin makes sure the user ADMIN_EMAIL exists and is an admin, creating it with ADMIN_PASS if needed
+func seedAdmin(repo services.UserRepository) {
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		return
+	}
+
+	usr, cerr := repo.FindByEmail(email)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		pass := os.Getenv("ADMIN_PASS")
+		if pass == "" {
+			log.Fatal("ADMIN_PASS is required to create the admin user")
+		}
+		usr = &models.User{Name: "admin", Email: email, Verified: true, Roles: []string{models.RoleAdmin}}
+		if err := usr.HashPass(pass); err != nil {
+			log.Fatal(err)
+		}
+		if _, cerr = repo.Create(usr); cerr != nil {
+			log.Fatal(cerr)
+		}
+		return
+	} else if cerr != nil {
+		log.Fatal(cerr)
+	}
+
+	if !models.HasPermission(usr.Roles, models.PermManageRoles) {
+		usr.Roles = append(usr.Roles, models.RoleAdmin)
+		if cerr = repo.Update(usr); cerr != nil {
+			log.Fatal(cerr)
+		}
+	}
+}
+
+//defaultLocale is DEFAULT_LOCALE, the BCP 47 language tag category names are written in, English by default
+func defaultLocale() language.Tag {
+	locale := os.Getenv("DEFAULT_LOCALE")
+	if locale == "" {
+		return language.English
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tag
+}
+
+//newKeyManager loads the keys from JWT_KEY_FILE, PEM encoded RSA or Ed25519 private keys. The first one signs,
+//the others verify the tokens they signed before they were replaced, see keys.Manager.LoadPEM.
+//Without one a key of JWT_ALG (RS256 by default) is generated and rotated monthly, which is fine for a single instance
+func newKeyManager() *keys.Manager {
+	alg := os.Getenv("JWT_ALG")
+	if alg == "" {
+		alg = keys.RS256
+	}
+
+	keyMgr, err := keys.NewManager(alg, services.MaxTokenTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	file := os.Getenv("JWT_KEY_FILE")
+	if file != "" {
+		var data []byte
+		if data, err = os.ReadFile(file); err != nil {
+			log.Fatal(err)
+		}
+		err = keyMgr.LoadPEM(data)
+	} else {
+		err = keyMgr.Rotate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//a key file is shared with the other instances and the services trusting it, so only generated keys are rotated here
+	if file == "" {
+		go keyMgr.Run(30*24*time.Hour, make(chan struct{}))
+	}
+
+	return keyMgr
+}
+
+//newSecretBox seals secrets at rest with TOTP_KEY, a base64 encoded 32 byte key.
+//Without one a random key is used, so sealed secrets can't be opened after a restart
+func newSecretBox() *shared.SecretBox {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("TOTP_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(key) == 0 {
+		fmt.Fprintln(os.Stderr, "TOTP_KEY not set, using a random key")
+		key = make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	box, err := shared.NewSecretBox(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return box
+}
+
+//newMailer delivers through SMTP_HOST if set, otherwise writes emails to MAIL_DIR or, without one, to stdout
+func newMailer() services.Mailer {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return mail.NewSMTP(host, port, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASS"), os.Getenv("MAIL_FROM"))
+	}
+
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		mailer, err := mail.NewFile(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return mailer
+	}
+
+	return mail.NewMemory(os.Stdout)
+}
+
+//newProviders configures the OpenID Connect provider at OIDC_ISSUER, named OIDC_NAME ("oidc" by default),
+//with the client OIDC_CLIENT_ID and OIDC_CLIENT_SECRET. A provider that can't be discovered is left out
+func newProviders() []services.IdentityProvider {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	name := os.Getenv("OIDC_NAME")
+	if name == "" {
+		name = "oidc"
+	}
+
+	provider, err := oauth.NewOIDC(name, issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't configure provider %s: %v\n", name, err)
+		return nil
+	}
+
+	return []services.IdentityProvider{provider}
+}
+
+//apiURL is the address this API is served at, which providers redirect back to
+func apiURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+//appURL is the address of the frontend that links in emails point to
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
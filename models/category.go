@@ -1,6 +1,100 @@
 package models
 
+import "strings"
+
 type Category struct {
-	Id   uint64 `json:"id"`
-	Name string `json:"name"`
+	Id int32 `json:"id"`
+	//name and description in the default locale
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	//name and description in other locales, by BCP 47 language tag
+	Translations map[string]CategoryTranslation `json:"translations,omitempty"`
+	//locale Name and Description are in, only set when localized for a listing
+	Locale string `json:"locale,omitempty"`
+	//parent category, nil for top level categories
+	ParentID *int32 `json:"parent_id"`
+	//free-form lowercase labels such as a language, categories can be queued for by tag
+	Tags []string `json:"tags"`
+	//format of the matches played in the category
+	Rules MatchRules `json:"rules"`
+	//categories are listed in ascending position among their siblings
+	Position int32 `json:"position"`
+	//archived categories and their subcategories are hidden from the listing and can't be queued for,
+	//but keep their matches and ratings
+	Archived bool `json:"archived"`
+	//live activity, only set when listing categories and never stored
+	Stats *CategoryStats `json:"stats,omitempty"`
+}
+
+type CategoryTranslation struct {
+	Name string `json:"name" validate:"required,lte=64"`
+	//the description in the default locale is used if left out
+	Description string `json:"description" validate:"lte=500"`
+}
+
+type CategoryStats struct {
+	//users waiting in the queue of the category
+	Queued int64 `json:"queued"`
+	//pending and active matches
+	ActiveMatches int64 `json:"active_matches"`
+}
+
+//sort orders of category listings
+const (
+	CategorySortPosition = "position"
+	CategorySortName     = "name"
+	CategorySortQueued   = "queued"
+	CategorySortActive   = "active"
+)
+
+//CategoryFilter narrows down and orders a category listing. Zero values don't filter
+type CategoryFilter struct {
+	//case insensitive part of the name
+	Query    string
+	Tag      string
+	ParentID *int32
+	Ranked   *bool
+	//one of the CategorySort orders, position if empty
+	Sort   string
+	Offset int64
+	Limit  int64
+}
+
+//Matches reports whether category passes the name, tag, parent and ranked filters
+func (f *CategoryFilter) Matches(category *Category) bool {
+	if f.Query != "" && !strings.Contains(strings.ToLower(category.Name), strings.ToLower(f.Query)) {
+		return false
+	}
+	if f.Tag != "" && !category.HasTag(f.Tag) {
+		return false
+	}
+	if f.ParentID != nil && (category.ParentID == nil || *category.ParentID != *f.ParentID) {
+		return false
+	}
+	if f.Ranked != nil && *f.Ranked != category.Rules.Ranked {
+		return false
+	}
+
+	return true
+}
+
+type CategoryPage struct {
+	Categories []Category `json:"categories"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+//HasTag reports whether the category is labeled tag
+func (c *Category) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//CategoryNode is a category along with its subcategories
+type CategoryNode struct {
+	Category
+	Children []CategoryNode `json:"children"`
 }
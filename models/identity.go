@@ -0,0 +1,12 @@
+package models
+
+//Identity is an account of a user at an external identity provider
+type Identity struct {
+	Provider string `json:"provider"`
+	//id of the account at the provider, stable unlike the email
+	Subject       string `json:"subject"`
+	UserID        int32  `json:"user_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+type LeaderboardEntry struct {
+	UserID int32   `json:"user_id"`
+	Rank   int64   `json:"rank"`
+	Rating float64 `json:"rating"`
+}
+
+type LeaderboardPage struct {
+	Entries    []LeaderboardEntry `json:"entries"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	//rank of the requesting user, nil if they haven't played in the category
+	Me *LeaderboardEntry `json:"me"`
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+//states of a match
+const (
+	MatchPending   = "pending"
+	MatchActive    = "active"
+	MatchFinished  = "finished"
+	MatchAbandoned = "abandoned"
+	MatchCancelled = "cancelled"
+)
+
+//outcomes of a match that is no longer in progress
+const (
+	OutcomeWin       = "win"
+	OutcomeDraw      = "draw"
+	OutcomeConceded  = "conceded"
+	OutcomeAbandoned = "abandoned"
+	//the match ran out of time and was decided by the rounds played
+	OutcomeTimeout = "timeout"
+	//a player never showed up, so the match never started
+	OutcomeNoShow = "no_show"
+)
+
+type Match struct {
+	Id         string  `json:"id"`
+	CategoryID int32   `json:"category_id"`
+	Players    []int32 `json:"players"`
+	//rules of the category when the match was created
+	Rules     MatchRules `json:"rules"`
+	State     string     `json:"state"`
+	CreatedAt time.Time  `json:"created_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	//when an active match with a time limit runs out
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	EndedAt  *time.Time `json:"ended_at,omitempty"`
+	Outcome  string     `json:"outcome,omitempty"`
+	WinnerID *int32     `json:"winner_id,omitempty"`
+	//score each player reported for themselves in the current round, the round is decided once the reports agree
+	Reports map[int32]float64 `json:"-"`
+	//score of the first player in each decided round
+	RoundScores []float64 `json:"round_scores,omitempty"`
+	//rating change of each player once the match is over
+	RatingChanges map[int32]float64 `json:"rating_changes,omitempty"`
+}
+
+//MatchFilter narrows down the match history of a user. Zero values don't filter
+type MatchFilter struct {
+	CategoryID *int32
+	From       time.Time
+	To         time.Time
+	Offset     int64
+	Limit      int64
+}
+
+//Matches reports whether match passes the category and date filters
+func (f *MatchFilter) Matches(match *Match) bool {
+	if f.CategoryID != nil && *f.CategoryID != match.CategoryID {
+		return false
+	}
+	if !f.From.IsZero() && match.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !match.CreatedAt.Before(f.To) {
+		return false
+	}
+
+	return true
+}
+
+//MatchHistoryEntry is a finished match from the point of view of one of its players
+type MatchHistoryEntry struct {
+	MatchID     string     `json:"match_id"`
+	CategoryID  int32      `json:"category_id"`
+	OpponentID  int32      `json:"opponent_id"`
+	State       string     `json:"state"`
+	Outcome     string     `json:"outcome"`
+	Result      string     `json:"result,omitempty"`
+	RatingDelta float64    `json:"rating_delta"`
+	Duration    float64    `json:"duration_seconds"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+}
+
+type MatchHistoryPage struct {
+	Entries    []MatchHistoryEntry `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+//InProgress reports whether the match is pending or active
+func (m *Match) InProgress() bool {
+	return m.State == MatchPending || m.State == MatchActive
+}
+
+func (m *Match) HasPlayer(userID int32) bool {
+	for _, p := range m.Players {
+		if p == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Opponent returns the other player of the match
+func (m *Match) Opponent(userID int32) int32 {
+	if m.Players[0] == userID {
+		return m.Players[1]
+	}
+
+	return m.Players[0]
+}
+
+//Score returns the total score of both players over the decided rounds, in the order of Players
+func (m *Match) Score() (float64, float64) {
+	var a float64
+	for _, score := range m.RoundScores {
+		a += score
+	}
+
+	return a, float64(len(m.RoundScores)) - a
+}
+
+//Decided reports whether enough rounds were played for the match to have a winner or a draw,
+//that is when every round was played or the leader can't be caught up with anymore
+func (m *Match) Decided() bool {
+	a, b := m.Score()
+	remaining := float64(m.Rules.RoundCount() - len(m.RoundScores))
+
+	return remaining <= 0 || math.Abs(a-b) > remaining
+}
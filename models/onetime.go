@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+//OneTimeToken is a single use secret mailed to a user. Only the hash of the secret is stored
+type OneTimeToken struct {
+	Hash string `json:"-"`
+	//what the token can be exchanged for, e.g. a password reset
+	Purpose string `json:"purpose"`
+	UserID  int32  `json:"user_id"`
+	//address the token was mailed to, if it matters which one it was
+	Email string `json:"email,omitempty"`
+	//whatever else the purpose needs to keep until the token is redeemed
+	Data      string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type QueueEntry struct {
+	UserID int32 `json:"user_id"`
+	//category the user waits in, an ancestor of RequestedCategoryID once the entry fell back
+	CategoryID int32 `json:"category_id" validate:"required"`
+	//category the user asked for
+	RequestedCategoryID int32     `json:"requested_category_id"`
+	Rating              float64   `json:"rating"`
+	JoinedAt            time.Time `json:"joined_at"`
+	//when the entry started waiting in CategoryID
+	MovedAt time.Time `json:"moved_at"`
+}
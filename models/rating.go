@@ -0,0 +1,11 @@
+package models
+
+type Rating struct {
+	UserID     int32   `json:"user_id"`
+	CategoryID int32   `json:"category_id"`
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+	//id of the last season whose close soft reset the rating
+	ResetSeason int32 `json:"-"`
+}
@@ -0,0 +1,42 @@
+package models
+
+//roles a user can have, every user is implicitly RoleUser
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+//permissions granted by roles
+const (
+	//use the /admin endpoints at all
+	PermAdminAccess      = "admin:access"
+	PermManageCategories = "categories:manage"
+	PermModerateUsers    = "users:moderate"
+	PermManageRoles      = "roles:manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermAdminAccess, PermModerateUsers},
+	RoleAdmin:     {PermAdminAccess, PermManageCategories, PermModerateUsers, PermManageRoles},
+}
+
+//ValidRole reports whether role exists
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+//HasPermission reports whether any of roles grants perm
+func HasPermission(roles []string, perm string) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+
+	return false
+}
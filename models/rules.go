@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+//kinds of chat messages, telling clients how to render the text of a message
+const (
+	MessageText  = "text"
+	MessageEmoji = "emoji"
+	MessageImage = "image"
+)
+
+//MatchRules set the format of the matches of a category. Zero values don't restrict
+type MatchRules struct {
+	//how long an active match may last, 0 for no limit
+	DurationSeconds int32 `json:"duration_seconds" validate:"gte=0"`
+	//the match is best of Rounds, 0 counts as a single round
+	Rounds int32 `json:"rounds" validate:"gte=0,lte=15"`
+	//rating a user needs to queue for the category
+	MinRating float64 `json:"min_rating" validate:"gte=0"`
+	MaxRating float64 `json:"max_rating" validate:"gte=0"`
+	//whether the outcome of matches changes ratings
+	Ranked bool `json:"ranked"`
+	//chat message kinds players may send, any if empty
+	MessageTypes []string `json:"message_types" validate:"dive,oneof=text emoji image"`
+}
+
+var DefaultMatchRules = MatchRules{
+	Rounds: 1,
+	Ranked: true,
+}
+
+func (r *MatchRules) Duration() time.Duration {
+	return time.Duration(r.DurationSeconds) * time.Second
+}
+
+//RoundCount returns the number of rounds a match is played over
+func (r *MatchRules) RoundCount() int {
+	if r.Rounds < 1 {
+		return 1
+	}
+	return int(r.Rounds)
+}
+
+//AllowsRating reports whether a user with rating may queue for the category
+func (r *MatchRules) AllowsRating(rating float64) bool {
+	return (r.MinRating == 0 || rating >= r.MinRating) && (r.MaxRating == 0 || rating <= r.MaxRating)
+}
+
+//AllowsMessage reports whether players may send chat messages of kind
+func (r *MatchRules) AllowsMessage(kind string) bool {
+	if len(r.MessageTypes) == 0 {
+		return true
+	}
+	for _, t := range r.MessageTypes {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
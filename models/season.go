@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type Season struct {
+	Id       int32     `json:"id"`
+	Name     string    `json:"name"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Closed   bool      `json:"closed"`
+	//set once ratings were soft reset from the standings of the closed season
+	RatingsReset bool `json:"-"`
+}
+
+//SeasonStanding is the rank of a user in a category at the close of a season
+type SeasonStanding struct {
+	SeasonID   int32   `json:"season_id"`
+	CategoryID int32   `json:"category_id"`
+	UserID     int32   `json:"user_id"`
+	Rank       int64   `json:"rank"`
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation,omitempty"`
+}
+
+type StandingsPage struct {
+	Season     *Season          `json:"season"`
+	Entries    []SeasonStanding `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+//Session is a login of a user, i.e. a token family
+type Session struct {
+	Id         string    `json:"id"`
+	UserID     int32     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	//whether the session is the one of the requesting token
+	Current bool `json:"current"`
+}
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+//FailedAttempts counts the failures recorded under a throttling key, e.g. the logins of an account
+type FailedAttempts struct {
+	Count int64     `json:"count"`
+	Last  time.Time `json:"last"`
+}
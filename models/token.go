@@ -0,0 +1,22 @@
+package models
+
+//Token is the stored state of an issued jwt, keyed by the uuid claim
+type Token struct {
+	UUID   string `json:"uuid"`
+	UserID int32  `json:"user_id"`
+	//every login starts a new family, refreshing rotates tokens within it
+	Family string `json:"family"`
+	Type   string `json:"type"`
+	//uuid of the access token issued together with a refresh token
+	Pair string `json:"pair,omitempty"`
+	//set once a refresh token has been exchanged
+	Used bool `json:"used"`
+}
+
+//LoginResult holds the token pair or, when the user has two-factor authentication enabled,
+//the challenge to answer with a second factor instead
+type LoginResult struct {
+	AccessToken    string `json:"access_token,omitempty"`
+	RefreshToken   string `json:"refresh_token,omitempty"`
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
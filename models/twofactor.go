@@ -0,0 +1,8 @@
+package models
+
+//TOTPEnrollment is what an authenticator app needs to generate codes for the user
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	//otpauth URI of the secret, usually shown as a QR code
+	URI string `json:"uri"`
+}
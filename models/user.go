@@ -9,6 +9,18 @@ type User struct {
 	Name  string `json:"name,omitempty" validate:"lte=20"`
 	Email string `json:"email,omitempty" validate:"required,email"`
 	Pass  string `json:"pass,omitempty" validate:"required,gte=6"`
+	//set once the user proved they own the email
+	Verified bool `json:"verified"`
+	//roles beyond RoleUser, which every user has
+	Roles []string `json:"roles"`
+	//TOTP secret sealed with the secret box, set from enrollment on
+	TOTPSecret string `json:"-"`
+	//set once the user confirmed enrollment with a valid code, logins then require a second factor
+	TOTPEnabled bool `json:"totp_enabled"`
+	//time step of the last accepted code, so that a code can't be replayed
+	TOTPLastStep int64 `json:"-"`
+	//hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-"`
 }
 
 func (usr *User) HashPass(pass string) error {
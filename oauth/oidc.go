@@ -0,0 +1,281 @@
+//Package oauth implements OAuth 2.0 authorization code logins with PKCE against external identity providers
+package oauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"mmr/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//keys of an issuer are refetched at most this often when a token is signed with an unknown one
+const jwksRefreshInterval = 10 * time.Second
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//OIDC is a provider speaking OpenID Connect, configured from the discovery document of its issuer
+type OIDC struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	authURL      string
+	tokenURL     string
+	jwksURL      string
+	client       *http.Client
+	//kid -> verification key of id tokens
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	mu          sync.Mutex
+}
+
+//NewOIDC fetches the discovery document of issuer and the keys it signs id tokens with
+func NewOIDC(name, issuer, clientID, clientSecret string, client *http.Client) (*OIDC, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var doc discovery
+	if err := getJSON(client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("unable to discover %s: %v", issuer, err)
+	}
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document of %s is for issuer %s", issuer, doc.Issuer)
+	}
+
+	o := &OIDC{
+		name:         name,
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       defaultScopes,
+		authURL:      doc.AuthorizationEndpoint,
+		tokenURL:     doc.TokenEndpoint,
+		jwksURL:      doc.JWKSURI,
+		client:       client,
+		mu:           sync.Mutex{},
+	}
+	if err := o.fetchKeys(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *OIDC) Name() string {
+	return o.name
+}
+
+//AuthURL returns where to send the user to log in at the provider
+func (o *OIDC) AuthURL(state, nonce, challenge, redirectURI string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", o.clientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", strings.Join(o.scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(o.authURL, "?") {
+		sep = "&"
+	}
+	return o.authURL + sep + v.Encode()
+}
+
+//Exchange trades the authorization code for an id token and returns the identity it asserts
+func (o *OIDC) Exchange(code, verifier, redirectURI, nonce string) (*models.Identity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", o.clientID)
+	form.Set("code_verifier", verifier)
+	if o.clientSecret != "" {
+		form.Set("client_secret", o.clientSecret)
+	}
+
+	resp, err := o.client.PostForm(o.tokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("unable to decode token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("token request failed with %d: %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("token response has no id token")
+	}
+
+	return o.verify(tr.IDToken, nonce)
+}
+
+//verify checks the signature and claims of an id token
+func (o *OIDC) verify(idToken, nonce string) (*models.Identity, error) {
+	token, err := jwt.Parse(idToken, o.keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid id token claims")
+	}
+
+	if !claims.VerifyIssuer(o.issuer, true) {
+		return nil, fmt.Errorf("id token issued by %v", claims["iss"])
+	}
+	if !audienceContains(claims["aud"], o.clientID) {
+		return nil, fmt.Errorf("id token issued to %v", claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token doesn't expire")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	identity := &models.Identity{Provider: o.name}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	//some providers send the flag as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return identity, nil
+}
+
+func (o *OIDC) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	stale := time.Since(o.keysFetched) > jwksRefreshInterval
+	o.mu.Unlock()
+
+	//the issuer may have rotated its keys
+	if !ok && stale {
+		if err := o.fetchKeys(); err != nil {
+			return nil, err
+		}
+		o.mu.Lock()
+		key, ok = o.keys[kid]
+		o.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	//the key decides the algorithm, never the token
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, rsaOK := token.Method.(*jwt.SigningMethodRSA)
+		_, pssOK := token.Method.(*jwt.SigningMethodRSAPSS)
+		ok = rsaOK || pssOK
+	case *ecdsa.PublicKey:
+		_, ok = token.Method.(*jwt.SigningMethodECDSA)
+	case ed25519.PublicKey:
+		_, ok = token.Method.(*jwt.SigningMethodEd25519)
+	default:
+		ok = false
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key, nil
+}
+
+func (o *OIDC) fetchKeys() error {
+	var set jwkSet
+	if err := getJSON(o.client, o.jwksURL, &set); err != nil {
+		return fmt.Errorf("unable to fetch keys of %s: %v", o.issuer, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			//skip keys of types we can't use, the issuer may publish others
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.keys = keys
+	o.keysFetched = time.Now()
+
+	return nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, _ := a.(string); s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
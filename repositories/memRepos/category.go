@@ -3,29 +3,121 @@ package memRepos
 import (
 	Cerr "mmr/errors"
 	"mmr/models"
+	"sort"
+	"strings"
 	"sync"
 )
 
+//StoredCategory is a category as it's stored. Rules replace the rules of Category, nil for categories stored before
+//they had rules, as the NULL rules of pgRepos
+type StoredCategory struct {
+	Category models.Category
+	Rules    *models.MatchRules
+}
+
+//category returns the stored category, with models.DefaultMatchRules if it was stored without rules
+func (stored *StoredCategory) category() models.Category {
+	category := stored.Category
+	category.Rules = models.DefaultMatchRules
+	if stored.Rules != nil {
+		category.Rules = *stored.Rules
+	}
+	return category
+}
+
+func newStoredCategory(category *models.Category) StoredCategory {
+	rules := category.Rules
+	return StoredCategory{Category: *category, Rules: &rules}
+}
+
 type Category struct {
-	storage map[int32]models.Category
-	mu      sync.Mutex
+	storage   map[int32]StoredCategory
+	currentID int32
+	mu        sync.Mutex
 }
 
-func NewCategory(storage map[int32]models.Category) *Category {
+func NewCategory(storage map[int32]StoredCategory, startID int32) *Category {
 	return &Category{
-		storage: storage,
-		mu:      sync.Mutex{},
+		storage:   storage,
+		currentID: startID,
+		mu:        sync.Mutex{},
+	}
+}
+
+//Create adds the category after every other one
+func (ctg *Category) Create(category *models.Category) (int32, Cerr.CError) {
+	ctg.mu.Lock()
+	defer ctg.mu.Unlock()
+
+	//currentID isn't taken yet, so any category with the name conflicts
+	if ctg.nameTaken(ctg.currentID, category.Name) {
+		return 0, Cerr.NewExists("name")
+	}
+
+	var position int32
+	for _, stored := range ctg.storage {
+		if stored.Category.Position >= position {
+			position = stored.Category.Position + 1
+		}
+	}
+
+	category.Id = ctg.currentID
+	category.Position = position
+	ctg.storage[ctg.currentID] = newStoredCategory(category)
+	ctg.currentID += 1
+
+	return category.Id, nil
+}
+
+func (ctg *Category) Update(category *models.Category) Cerr.CError {
+	ctg.mu.Lock()
+	defer ctg.mu.Unlock()
+
+	if _, ok := ctg.storage[category.Id]; !ok {
+		return Cerr.NewNotFound("category id")
+	}
+	if ctg.nameTaken(category.Id, category.Name) {
+		return Cerr.NewExists("name")
+	}
+	ctg.storage[category.Id] = newStoredCategory(category)
+
+	return nil
+}
+
+//Reorder sets the position of every category in ids to its index
+func (ctg *Category) Reorder(ids []int32) Cerr.CError {
+	ctg.mu.Lock()
+	defer ctg.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := ctg.storage[id]; !ok {
+			return Cerr.NewNotFound("category id")
+		}
+	}
+	for i, id := range ids {
+		stored := ctg.storage[id]
+		stored.Category.Position = int32(i)
+		ctg.storage[id] = stored
 	}
+
+	return nil
 }
 
+//List returns all categories, archived ones included, by position
 func (ctg *Category) List() ([]models.Category, Cerr.CError) {
 	ctg.mu.Lock()
 	defer ctg.mu.Unlock()
 
 	categories := make([]models.Category, 0, len(ctg.storage))
-	for _, category := range ctg.storage {
-		categories = append(categories, category)
+	for _, stored := range ctg.storage {
+		categories = append(categories, stored.category())
 	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Position == categories[j].Position {
+			return categories[i].Id < categories[j].Id
+		}
+		return categories[i].Position < categories[j].Position
+	})
 
 	return categories, nil
 }
@@ -34,10 +126,21 @@ func (ctg *Category) Get(id int32) (*models.Category, Cerr.CError) {
 	ctg.mu.Lock()
 	defer ctg.mu.Unlock()
 
-	category, ok := ctg.storage[id]
+	stored, ok := ctg.storage[id]
 	if !ok {
 		return nil, Cerr.NewNotFound("category id")
 	}
+	category := stored.category()
 
 	return &category, nil
 }
+
+//nameTaken reports whether a category other than id has name, ignoring case. Must be called with the lock held
+func (ctg *Category) nameTaken(id int32, name string) bool {
+	for _, stored := range ctg.storage {
+		if stored.Category.Id != id && strings.EqualFold(stored.Category.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sync"
+)
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type Identity struct {
+	storage map[identityKey]models.Identity
+	mu      sync.Mutex
+}
+
+func NewIdentity() *Identity {
+	return &Identity{
+		storage: make(map[identityKey]models.Identity),
+		mu:      sync.Mutex{},
+	}
+}
+
+func (i *Identity) Get(provider, subject string) (*models.Identity, Cerr.CError) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	identity, ok := i.storage[identityKey{provider, subject}]
+	if !ok {
+		return nil, Cerr.NewNotFound("identity")
+	}
+
+	return &identity, nil
+}
+
+func (i *Identity) Create(identity *models.Identity) Cerr.CError {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := identityKey{identity.Provider, identity.Subject}
+	if _, ok := i.storage[key]; ok {
+		return Cerr.NewExists("identity")
+	}
+	i.storage[key] = *identity
+
+	return nil
+}
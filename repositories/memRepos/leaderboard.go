@@ -0,0 +1,91 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sort"
+	"sync"
+)
+
+type Leaderboard struct {
+	//categoryID -> userID -> rating
+	storage map[int32]map[int32]float64
+	mu      sync.Mutex
+}
+
+func NewLeaderboard(storage map[int32]map[int32]float64) *Leaderboard {
+	return &Leaderboard{
+		storage: storage,
+		mu:      sync.Mutex{},
+	}
+}
+
+//Set updates the ratings of several users of the category at once
+func (lb *Leaderboard) Set(categoryID int32, ratings map[int32]float64) Cerr.CError {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if _, ok := lb.storage[categoryID]; !ok {
+		lb.storage[categoryID] = make(map[int32]float64)
+	}
+	for userID, rating := range ratings {
+		lb.storage[categoryID][userID] = rating
+	}
+
+	return nil
+}
+
+//Range returns up to limit entries ranked after the entry after, or from the top if it is nil
+func (lb *Leaderboard) Range(categoryID int32, after *models.LeaderboardEntry, limit int64) ([]models.LeaderboardEntry, Cerr.CError) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	ranked := lb.ranked(categoryID)
+	start := 0
+	if after != nil {
+		start = sort.Search(len(ranked), func(i int) bool {
+			return ranked[i].Rating < after.Rating || (ranked[i].Rating == after.Rating && ranked[i].UserID > after.UserID)
+		})
+	}
+	end := int64(start) + limit
+	if end > int64(len(ranked)) {
+		end = int64(len(ranked))
+	}
+
+	return ranked[start:end], nil
+}
+
+func (lb *Leaderboard) Rank(categoryID, userID int32) (*models.LeaderboardEntry, Cerr.CError) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, entry := range lb.ranked(categoryID) {
+		if entry.UserID == userID {
+			return &entry, nil
+		}
+	}
+
+	return nil, Cerr.NewNotFound("leaderboard entry")
+}
+
+//ranked orders users by rating, ties by ascending id as in redis. Must be called with the lock held
+func (lb *Leaderboard) ranked(categoryID int32) []models.LeaderboardEntry {
+	entries := make([]models.LeaderboardEntry, 0, len(lb.storage[categoryID]))
+	for userID, rating := range lb.storage[categoryID] {
+		entries = append(entries, models.LeaderboardEntry{
+			UserID: userID,
+			Rating: rating,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Rating == entries[j].Rating {
+			return entries[i].UserID < entries[j].UserID
+		}
+		return entries[i].Rating > entries[j].Rating
+	})
+	for i := range entries {
+		entries[i].Rank = int64(i) + 1
+	}
+
+	return entries
+}
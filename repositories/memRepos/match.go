@@ -0,0 +1,116 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sort"
+	"sync"
+)
+
+type Match struct {
+	storage map[string]models.Match
+	mu      sync.Mutex
+}
+
+func NewMatch(storage map[string]models.Match) *Match {
+	return &Match{
+		storage: storage,
+		mu:      sync.Mutex{},
+	}
+}
+
+func (m *Match) Get(id string) (*models.Match, Cerr.CError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	match, ok := m.storage[id]
+	if !ok {
+		return nil, Cerr.NewNotFound("match")
+	}
+
+	return copyMatch(&match), nil
+}
+
+func (m *Match) Set(match *models.Match) Cerr.CError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.storage[match.Id] = *copyMatch(match)
+
+	return nil
+}
+
+//FindCurrent returns the pending or active match of the user
+func (m *Match) FindCurrent(userID int32) (*models.Match, Cerr.CError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, match := range m.storage {
+		if match.InProgress() && match.HasPlayer(userID) {
+			return copyMatch(&match), nil
+		}
+	}
+
+	return nil, Cerr.NewNotFound("match")
+}
+
+//CountInProgress returns the number of pending and active matches of each category that has any
+func (m *Match) CountInProgress() (map[int32]int64, Cerr.CError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[int32]int64)
+	for _, match := range m.storage {
+		if match.InProgress() {
+			counts[match.CategoryID]++
+		}
+	}
+
+	return counts, nil
+}
+
+//ListByUser returns the matches of the user that are over, most recent first
+func (m *Match) ListByUser(userID int32, filter *models.MatchFilter) ([]models.Match, Cerr.CError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matches := make([]models.Match, 0)
+	for _, match := range m.storage {
+		if !match.InProgress() && match.HasPlayer(userID) && filter.Matches(&match) {
+			matches = append(matches, *copyMatch(&match))
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+
+	if filter.Offset >= int64(len(matches)) {
+		return make([]models.Match, 0), nil
+	}
+	end := filter.Offset + filter.Limit
+	if end > int64(len(matches)) {
+		end = int64(len(matches))
+	}
+
+	return matches[filter.Offset:end], nil
+}
+
+//copyMatch makes sure callers can't modify stored slices and maps
+func copyMatch(match *models.Match) *models.Match {
+	c := *match
+	c.Players = append([]int32(nil), match.Players...)
+	c.Rules.MessageTypes = append([]string(nil), match.Rules.MessageTypes...)
+	c.RoundScores = append([]float64(nil), match.RoundScores...)
+	c.Reports = make(map[int32]float64, len(match.Reports))
+	for userID, score := range match.Reports {
+		c.Reports[userID] = score
+	}
+	if match.RatingChanges != nil {
+		c.RatingChanges = make(map[int32]float64, len(match.RatingChanges))
+		for userID, delta := range match.RatingChanges {
+			c.RatingChanges[userID] = delta
+		}
+	}
+
+	return &c
+}
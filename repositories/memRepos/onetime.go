@@ -0,0 +1,65 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sync"
+	"time"
+)
+
+type OneTimeToken struct {
+	//purpose -> hash -> token
+	storage map[string]map[string]models.OneTimeToken
+	mu      sync.Mutex
+}
+
+func NewOneTimeToken(storage map[string]map[string]models.OneTimeToken) *OneTimeToken {
+	return &OneTimeToken{
+		storage: storage,
+		mu:      sync.Mutex{},
+	}
+}
+
+func (ott *OneTimeToken) Set(token *models.OneTimeToken, exp time.Duration) Cerr.CError {
+	ott.mu.Lock()
+	defer ott.mu.Unlock()
+
+	if _, ok := ott.storage[token.Purpose]; !ok {
+		ott.storage[token.Purpose] = make(map[string]models.OneTimeToken)
+	}
+	ott.storage[token.Purpose][token.Hash] = *token
+
+	//remove the token after expiration time has elapsed
+	go func() {
+		time.Sleep(exp)
+		_, _ = ott.Take(token.Purpose, token.Hash)
+	}()
+
+	return nil
+}
+
+func (ott *OneTimeToken) Take(purpose, hash string) (*models.OneTimeToken, Cerr.CError) {
+	ott.mu.Lock()
+	defer ott.mu.Unlock()
+
+	token, ok := ott.storage[purpose][hash]
+	if !ok {
+		return nil, Cerr.NewUnauthorized("token")
+	}
+	delete(ott.storage[purpose], hash)
+
+	return &token, nil
+}
+
+func (ott *OneTimeToken) DelUser(purpose string, userID int32) Cerr.CError {
+	ott.mu.Lock()
+	defer ott.mu.Unlock()
+
+	for hash, token := range ott.storage[purpose] {
+		if token.UserID == userID {
+			delete(ott.storage[purpose], hash)
+		}
+	}
+
+	return nil
+}
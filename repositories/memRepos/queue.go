@@ -0,0 +1,93 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sort"
+	"sync"
+)
+
+type Queue struct {
+	storage map[int32]models.QueueEntry
+	mu      sync.Mutex
+}
+
+func NewQueue(storage map[int32]models.QueueEntry) *Queue {
+	return &Queue{
+		storage: storage,
+		mu:      sync.Mutex{},
+	}
+}
+
+func (q *Queue) Get(userID int32) (*models.QueueEntry, Cerr.CError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.storage[userID]
+	if !ok {
+		return nil, Cerr.NewNotFound("queue entry")
+	}
+
+	return &entry, nil
+}
+
+func (q *Queue) Set(entry *models.QueueEntry) Cerr.CError {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.storage[entry.UserID] = *entry
+
+	return nil
+}
+
+func (q *Queue) Del(userID int32) Cerr.CError {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.storage[userID]; !ok {
+		return Cerr.NewNotFound("queue entry")
+	}
+	delete(q.storage, userID)
+
+	return nil
+}
+
+//List returns entries waiting in the category, longest waiting first
+func (q *Queue) List(categoryID int32) ([]models.QueueEntry, Cerr.CError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]models.QueueEntry, 0)
+	for _, entry := range q.storage {
+		if entry.CategoryID == categoryID {
+			entries = append(entries, entry)
+		}
+	}
+	sortEntries(entries)
+
+	return entries, nil
+}
+
+//ListAll returns all waiting entries, longest waiting first
+func (q *Queue) ListAll() ([]models.QueueEntry, Cerr.CError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]models.QueueEntry, 0, len(q.storage))
+	for _, entry := range q.storage {
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+
+	return entries, nil
+}
+
+//sortEntries orders entries by join time, breaking ties by user id so the order is deterministic
+func sortEntries(entries []models.QueueEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].JoinedAt.Equal(entries[j].JoinedAt) {
+			return entries[i].UserID < entries[j].UserID
+		}
+		return entries[i].JoinedAt.Before(entries[j].JoinedAt)
+	})
+}
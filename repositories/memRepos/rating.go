@@ -0,0 +1,70 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sync"
+)
+
+type Rating struct {
+	//userID -> categoryID -> rating
+	storage map[int32]map[int32]models.Rating
+	mu      sync.Mutex
+}
+
+func NewRating(storage map[int32]map[int32]models.Rating) *Rating {
+	return &Rating{
+		storage: storage,
+		mu:      sync.Mutex{},
+	}
+}
+
+func (rt *Rating) Get(userID, categoryID int32) (*models.Rating, Cerr.CError) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rating, ok := rt.storage[userID][categoryID]
+	if !ok {
+		return nil, Cerr.NewNotFound("rating")
+	}
+
+	return &rating, nil
+}
+
+func (rt *Rating) Set(rating *models.Rating) Cerr.CError {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if _, ok := rt.storage[rating.UserID]; !ok {
+		rt.storage[rating.UserID] = make(map[int32]models.Rating)
+	}
+	rt.storage[rating.UserID][rating.CategoryID] = *rating
+
+	return nil
+}
+
+func (rt *Rating) ListByUser(userID int32) ([]models.Rating, Cerr.CError) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	ratings := make([]models.Rating, 0, len(rt.storage[userID]))
+	for _, rating := range rt.storage[userID] {
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, nil
+}
+
+func (rt *Rating) ListAll() ([]models.Rating, Cerr.CError) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	ratings := make([]models.Rating, 0)
+	for _, userRatings := range rt.storage {
+		for _, rating := range userRatings {
+			ratings = append(ratings, rating)
+		}
+	}
+
+	return ratings, nil
+}
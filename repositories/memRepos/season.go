@@ -0,0 +1,124 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sort"
+	"sync"
+)
+
+type Season struct {
+	storage   map[int32]models.Season
+	standings map[int32][]models.SeasonStanding
+	currentID int32
+	mu        sync.Mutex
+}
+
+func NewSeason(storage map[int32]models.Season, standings map[int32][]models.SeasonStanding, startID int32) *Season {
+	return &Season{
+		storage:   storage,
+		standings: standings,
+		currentID: startID,
+		mu:        sync.Mutex{},
+	}
+}
+
+func (s *Season) Create(season *models.Season) (int32, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	season.Id = s.currentID
+	s.storage[s.currentID] = *season
+	s.currentID += 1
+
+	return season.Id, nil
+}
+
+func (s *Season) Update(season *models.Season) Cerr.CError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.storage[season.Id]; !ok {
+		return Cerr.NewNotFound("season")
+	}
+	s.storage[season.Id] = *season
+
+	return nil
+}
+
+func (s *Season) Get(id int32) (*models.Season, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	season, ok := s.storage[id]
+	if !ok {
+		return nil, Cerr.NewNotFound("season")
+	}
+
+	return &season, nil
+}
+
+//Current returns the season that hasn't been closed yet
+func (s *Season) Current() (*models.Season, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, season := range s.storage {
+		if !season.Closed {
+			return &season, nil
+		}
+	}
+
+	return nil, Cerr.NewNotFound("season")
+}
+
+//List returns all seasons, most recent first
+func (s *Season) List() ([]models.Season, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seasons := make([]models.Season, 0, len(s.storage))
+	for _, season := range s.storage {
+		seasons = append(seasons, season)
+	}
+	sort.Slice(seasons, func(i, j int) bool {
+		return seasons[i].StartsAt.After(seasons[j].StartsAt)
+	})
+
+	return seasons, nil
+}
+
+func (s *Season) SetStandings(seasonID int32, standings []models.SeasonStanding) Cerr.CError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.standings[seasonID] = append([]models.SeasonStanding(nil), standings...)
+
+	return nil
+}
+
+//ListStandings returns up to limit standings of the category starting at offset, best rank first
+func (s *Season) ListStandings(seasonID, categoryID int32, offset, limit int64) ([]models.SeasonStanding, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	standings := make([]models.SeasonStanding, 0)
+	for _, standing := range s.standings[seasonID] {
+		if standing.CategoryID == categoryID {
+			standings = append(standings, standing)
+		}
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		return standings[i].Rank < standings[j].Rank
+	})
+
+	if offset >= int64(len(standings)) {
+		return make([]models.SeasonStanding, 0), nil
+	}
+	end := offset + limit
+	if end > int64(len(standings)) {
+		end = int64(len(standings))
+	}
+
+	return standings[offset:end], nil
+}
@@ -0,0 +1,71 @@
+package memRepos
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"sync"
+	"time"
+)
+
+type throttleEntry struct {
+	attempts  models.FailedAttempts
+	expiresAt time.Time
+}
+
+type Throttle struct {
+	storage map[string]throttleEntry
+	mu      sync.Mutex
+}
+
+func NewThrottle() *Throttle {
+	return &Throttle{
+		storage: make(map[string]throttleEntry),
+		mu:      sync.Mutex{},
+	}
+}
+
+func (t *Throttle) Reserve(key string, at time.Time, window time.Duration,
+	delay func(attempts int64) time.Duration) (time.Duration, Cerr.CError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.storage[key]
+	if !ok || at.After(entry.expiresAt) {
+		entry = throttleEntry{}
+	}
+	if wait := entry.attempts.Last.Add(delay(entry.attempts.Count)).Sub(at); wait > 0 {
+		return wait, nil
+	}
+	entry.attempts.Count++
+	entry.attempts.Last = at
+	entry.expiresAt = at.Add(window)
+	t.storage[key] = entry
+
+	return 0, nil
+}
+
+func (t *Throttle) Release(key string) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.storage[key]
+	if !ok {
+		return nil
+	}
+	if entry.attempts.Count <= 1 {
+		delete(t.storage, key)
+		return nil
+	}
+	entry.attempts.Count--
+	t.storage[key] = entry
+
+	return nil
+}
+
+func (t *Throttle) Reset(key string) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.storage, key)
+	return nil
+}
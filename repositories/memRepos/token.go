@@ -2,55 +2,168 @@ package memRepos
 
 import (
 	Cerr "mmr/errors"
+	"mmr/models"
 	"sync"
 	"time"
 )
 
 type Token struct {
-	storage map[string]int32
-	mu      sync.Mutex
+	storage map[string]models.Token
+	//family -> uuids of the tokens in it
+	families map[string]map[string]struct{}
+	//family -> session, removed together with the last token of the family
+	sessions map[string]models.Session
+	mu       sync.Mutex
 }
 
-func NewToken(storage map[string]int32) *Token {
+func NewToken(storage map[string]models.Token) *Token {
 	return &Token{
-		storage: storage,
-		mu:      sync.Mutex{},
+		storage:  storage,
+		families: make(map[string]map[string]struct{}),
+		sessions: make(map[string]models.Session),
+		mu:       sync.Mutex{},
 	}
 }
 
-func (t *Token) Get(uuid string) (int32, Cerr.CError) {
+func (t *Token) Get(uuid string) (*models.Token, Cerr.CError) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	userID, ok := t.storage[uuid]
+	token, ok := t.storage[uuid]
 	if !ok {
-		return -1, Cerr.NewUnauthorized("token")
+		return nil, Cerr.NewUnauthorized("token")
 	}
 
-	return userID, nil
+	return &token, nil
 }
 
 //Set method operates on the assumption that it won't be called on the same uuid more than once
 //Otherwise, a timer-based approach for deleting expired tokens needs to be implemented
-func (t *Token) Set(uuid string, userID int32, exp time.Duration) Cerr.CError {
+func (t *Token) Set(token *models.Token, exp time.Duration) Cerr.CError {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	t.storage[uuid] = userID
+	t.storage[token.UUID] = *token
+	if _, ok := t.families[token.Family]; !ok {
+		t.families[token.Family] = make(map[string]struct{})
+	}
+	t.families[token.Family][token.UUID] = struct{}{}
+
 	//remove the token after expiration time has elapsed
 	go func() {
 		time.Sleep(exp)
-		_ = t.Del(uuid)
+		_ = t.Del(token.UUID)
 	}()
 
 	return nil
 }
 
+//Use marks the token as used and returns it as it was before, so that callers can detect reuse
+func (t *Token) Use(uuid string) (*models.Token, Cerr.CError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token, ok := t.storage[uuid]
+	if !ok {
+		return nil, Cerr.NewUnauthorized("token")
+	}
+
+	used := token
+	used.Used = true
+	t.storage[uuid] = used
+
+	return &token, nil
+}
+
 func (t *Token) Del(uuid string) Cerr.CError {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	delete(t.storage, uuid)
+	t.del(uuid)
+
+	return nil
+}
+
+//DelFamily removes every token of the family
+func (t *Token) DelFamily(family string) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for uuid := range t.families[family] {
+		t.del(uuid)
+	}
+
+	return nil
+}
+
+//SetSession stores the session of a family. It expires together with the last token of the family
+func (t *Token) SetSession(session *models.Session, _ time.Duration) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sessions[session.Id] = *session
+
+	return nil
+}
+
+//TouchSession updates the last use of the session
+func (t *Token) TouchSession(family string, lastUsed time.Time, _ time.Duration) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session, ok := t.sessions[family]
+	if !ok {
+		return Cerr.NewNotFound("session")
+	}
+	session.LastUsedAt = lastUsed
+	t.sessions[family] = session
 
 	return nil
 }
+
+func (t *Token) ListSessions(userID int32) ([]models.Session, Cerr.CError) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sessions := make([]models.Session, 0)
+	for _, session := range t.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+//DelUser removes every token of every family of the user
+func (t *Token) DelUser(userID int32) Cerr.CError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for family, session := range t.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		for uuid := range t.families[family] {
+			t.del(uuid)
+		}
+		delete(t.sessions, family)
+	}
+
+	return nil
+}
+
+//del must be called with the lock held
+func (t *Token) del(uuid string) {
+	token, ok := t.storage[uuid]
+	if !ok {
+		return
+	}
+
+	delete(t.storage, uuid)
+	delete(t.families[token.Family], uuid)
+	if len(t.families[token.Family]) == 0 {
+		delete(t.families, token.Family)
+		delete(t.sessions, token.Family)
+	}
+}
@@ -51,3 +51,56 @@ func (usr *User) FindByEmail(email string) (*models.User, Cerr.CError) {
 
 	return nil, Cerr.NewNotFound("email")
 }
+
+func (usr *User) Update(user *models.User) Cerr.CError {
+	usr.mu.Lock()
+	defer usr.mu.Unlock()
+	if _, ok := usr.storage[user.Id]; !ok {
+		return Cerr.NewNotFound("id")
+	}
+	for _, memUsr := range usr.storage {
+		if memUsr.Id != user.Id && memUsr.Email == user.Email {
+			return Cerr.NewExists("email")
+		}
+	}
+	usr.storage[user.Id] = *user
+
+	return nil
+}
+
+func (usr *User) UseTOTPStep(userID int32, step int64) (bool, Cerr.CError) {
+	usr.mu.Lock()
+	defer usr.mu.Unlock()
+	memUsr, ok := usr.storage[userID]
+	if !ok {
+		return false, Cerr.NewNotFound("id")
+	}
+	if step <= memUsr.TOTPLastStep {
+		return false, nil
+	}
+	memUsr.TOTPLastStep = step
+	usr.storage[userID] = memUsr
+
+	return true, nil
+}
+
+func (usr *User) UseRecoveryCode(userID int32, hash string) (bool, Cerr.CError) {
+	usr.mu.Lock()
+	defer usr.mu.Unlock()
+	memUsr, ok := usr.storage[userID]
+	if !ok {
+		return false, Cerr.NewNotFound("id")
+	}
+	for i, stored := range memUsr.RecoveryCodes {
+		if stored == hash {
+			//copies of the user share the old slice
+			remaining := make([]string, 0, len(memUsr.RecoveryCodes)-1)
+			remaining = append(remaining, memUsr.RecoveryCodes[:i]...)
+			memUsr.RecoveryCodes = append(remaining, memUsr.RecoveryCodes[i+1:]...)
+			usr.storage[userID] = memUsr
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -2,7 +2,11 @@ package pgRepos
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	cerr "mmr/errors"
@@ -10,6 +14,8 @@ import (
 	"os"
 )
 
+const categoryColumns = "id, name, description, translations, parent_id, tags, rules, position, archived"
+
 type Category struct {
 	p *pgxpool.Pool
 }
@@ -20,6 +26,97 @@ func NewCategory(p *pgxpool.Pool) *Category {
 	}
 }
 
+//Create adds the category after every other one. Names are unique ignoring case through a unique index on lower(name)
+func (ctg *Category) Create(category *models.Category) (int32, cerr.CError) {
+	conn, err := ctg.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return 0, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(),
+		`INSERT INTO categories(name, description, translations, parent_id, tags, rules, position, archived)
+		VALUES ($1, $2, $3, $4, $5, $6, (SELECT COALESCE(MAX(position) + 1, 0) FROM categories), $7)
+		RETURNING id, position`,
+		category.Name, category.Description, category.Translations, category.ParentID, category.Tags, category.Rules,
+		category.Archived)
+	if err = row.Scan(&category.Id, &category.Position); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return 0, cerr.NewExists("name")
+		}
+		fmt.Fprintf(os.Stderr, "Unable to INSERT category: %v\n", err)
+		return 0, cerr.NewInternal()
+	}
+
+	return category.Id, nil
+}
+
+func (ctg *Category) Update(category *models.Category) cerr.CError {
+	conn, err := ctg.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(context.TODO(),
+		`UPDATE categories SET name = $2, description = $3, translations = $4, parent_id = $5, tags = $6, rules = $7,
+		position = $8, archived = $9 WHERE id = $1`,
+		category.Id, category.Name, category.Description, category.Translations, category.ParentID, category.Tags,
+		category.Rules, category.Position, category.Archived)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return cerr.NewExists("name")
+		}
+		fmt.Fprintf(os.Stderr, "Unable to UPDATE category: %v\n", err)
+		return cerr.NewInternal()
+	}
+	if tag.RowsAffected() == 0 {
+		return cerr.NewNotFound("category")
+	}
+
+	return nil
+}
+
+//Reorder sets the position of every category in ids to its index. Nothing changes if some of the ids don't exist
+func (ctg *Category) Reorder(ids []int32) cerr.CError {
+	conn, err := ctg.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to begin a transaction: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer tx.Rollback(context.TODO())
+
+	tag, err := tx.Exec(context.TODO(),
+		`UPDATE categories SET position = o.position - 1
+		FROM unnest($1::int[]) WITH ORDINALITY AS o(id, position) WHERE categories.id = o.id`,
+		ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to UPDATE category positions: %v\n", err)
+		return cerr.NewInternal()
+	}
+	if tag.RowsAffected() != int64(len(ids)) {
+		return cerr.NewNotFound("category")
+	}
+	if err = tx.Commit(context.TODO()); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to commit category positions: %v\n", err)
+		return cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//List returns all categories, archived ones included, by position
 func (ctg *Category) List() ([]models.Category, cerr.CError) {
 	conn, err := ctg.p.Acquire(context.TODO())
 	if err != nil {
@@ -28,7 +125,7 @@ func (ctg *Category) List() ([]models.Category, cerr.CError) {
 	}
 	defer conn.Release()
 
-	rows, err := conn.Query(context.TODO(), "SELECT id, name FROM categories")
+	rows, err := conn.Query(context.TODO(), "SELECT "+categoryColumns+" FROM categories ORDER BY position, id")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to SELECT categories: %v\n", err)
 		return nil, cerr.NewInternal()
@@ -37,9 +134,8 @@ func (ctg *Category) List() ([]models.Category, cerr.CError) {
 	//skip rows with errors while scanning
 	categories := make([]models.Category, 0)
 	for rows.Next() {
-		var category models.Category
-		if err = rows.Scan(&category.Id, &category.Name); err == nil {
-			categories = append(categories, category)
+		if category, err := scanCategory(rows); err == nil {
+			categories = append(categories, *category)
 		}
 	}
 
@@ -65,15 +161,36 @@ func (ctg *Category) Get(id int32) (*models.Category, cerr.CError) {
 	defer conn.Release()
 
 	row := conn.QueryRow(context.TODO(),
-		"SELECT id, name FROM categories WHERE id = $1", id)
+		"SELECT "+categoryColumns+" FROM categories WHERE id = $1", id)
 
-	var category models.Category
-	if err = row.Scan(&category.Id, &category.Name); err == pgx.ErrNoRows {
+	category, err := scanCategory(row)
+	if err == pgx.ErrNoRows {
 		return nil, cerr.NewNotFound("category")
 	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Unable to SELECT category: %v", err)
 		return nil, cerr.NewInternal()
 	}
 
+	return category, nil
+}
+
+//scanCategory reads a category, filling in the rules it was stored without, NULL for categories created before
+//they existed, with models.DefaultMatchRules
+func scanCategory(row pgx.Row) (*models.Category, error) {
+	var category models.Category
+	var rules []byte
+	err := row.Scan(&category.Id, &category.Name, &category.Description, &category.Translations, &category.ParentID,
+		&category.Tags, &rules, &category.Position, &category.Archived)
+	if err != nil {
+		return nil, err
+	}
+
+	category.Rules = models.DefaultMatchRules
+	if rules != nil {
+		if err = json.Unmarshal(rules, &category.Rules); err != nil {
+			return nil, err
+		}
+	}
+
 	return &category, nil
 }
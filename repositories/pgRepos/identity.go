@@ -0,0 +1,81 @@
+package pgRepos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	cerr "mmr/errors"
+	"mmr/models"
+	"os"
+)
+
+const identityColumns = "provider, subject, user_id, email, email_verified, name"
+
+type Identity struct {
+	p *pgxpool.Pool
+}
+
+func NewIdentity(p *pgxpool.Pool) *Identity {
+	return &Identity{
+		p: p,
+	}
+}
+
+func (i *Identity) Get(provider, subject string) (*models.Identity, cerr.CError) {
+	conn, err := i.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(),
+		"SELECT "+identityColumns+" FROM identities WHERE provider = $1 AND subject = $2", provider, subject)
+	identity, err := scanIdentity(row)
+	if err == pgx.ErrNoRows {
+		return nil, cerr.NewNotFound("identity")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT identity: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return identity, nil
+}
+
+func (i *Identity) Create(identity *models.Identity) cerr.CError {
+	conn, err := i.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(context.TODO(),
+		"INSERT INTO identities("+identityColumns+") VALUES ($1, $2, $3, $4, $5, $6)",
+		identity.Provider, identity.Subject, identity.UserID, identity.Email, identity.EmailVerified, identity.Name)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return cerr.NewExists("identity")
+		}
+		fmt.Fprintf(os.Stderr, "Unable to INSERT identity: %v\n", err)
+		return cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func scanIdentity(row pgx.Row) (*models.Identity, error) {
+	var identity models.Identity
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.EmailVerified,
+		&identity.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
@@ -0,0 +1,189 @@
+package pgRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"time"
+)
+
+const matchColumns = "id, category_id, players, rules, state, created_at, started_at, ends_at, ended_at, outcome, " +
+	"winner_id, reports, round_scores, rating_changes"
+
+type Match struct {
+	p *pgxpool.Pool
+}
+
+func NewMatch(p *pgxpool.Pool) *Match {
+	return &Match{
+		p: p,
+	}
+}
+
+func (m *Match) Get(id string) (*models.Match, cerr.CError) {
+	conn, err := m.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(), "SELECT "+matchColumns+" FROM matches WHERE id = $1", id)
+	match, err := scanMatch(row)
+	if err == pgx.ErrNoRows {
+		return nil, cerr.NewNotFound("match")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT match: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return match, nil
+}
+
+func (m *Match) Set(match *models.Match) cerr.CError {
+	conn, err := m.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(context.TODO(),
+		`INSERT INTO matches(`+matchColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (id) DO UPDATE
+		SET state = EXCLUDED.state, started_at = EXCLUDED.started_at, ends_at = EXCLUDED.ends_at,
+		ended_at = EXCLUDED.ended_at, outcome = EXCLUDED.outcome, winner_id = EXCLUDED.winner_id,
+		reports = EXCLUDED.reports, round_scores = EXCLUDED.round_scores, rating_changes = EXCLUDED.rating_changes`,
+		match.Id, match.CategoryID, match.Players, match.Rules, match.State, match.CreatedAt, match.StartedAt,
+		match.EndsAt, match.EndedAt, match.Outcome, match.WinnerID, match.Reports, match.RoundScores, match.RatingChanges)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to UPSERT match: %v\n", err)
+		return cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//FindCurrent returns the pending or active match of the user
+func (m *Match) FindCurrent(userID int32) (*models.Match, cerr.CError) {
+	conn, err := m.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(),
+		"SELECT "+matchColumns+" FROM matches WHERE $1 = ANY(players) AND state IN ($2, $3) LIMIT 1",
+		userID, models.MatchPending, models.MatchActive)
+	match, err := scanMatch(row)
+	if err == pgx.ErrNoRows {
+		return nil, cerr.NewNotFound("match")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT current match: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return match, nil
+}
+
+//CountInProgress returns the number of pending and active matches of each category that has any
+func (m *Match) CountInProgress() (map[int32]int64, cerr.CError) {
+	conn, err := m.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.TODO(),
+		"SELECT category_id, COUNT(*) FROM matches WHERE state IN ($1, $2) GROUP BY category_id",
+		models.MatchPending, models.MatchActive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT match counts: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer rows.Close()
+
+	counts := make(map[int32]int64)
+	for rows.Next() {
+		var categoryID int32
+		var count int64
+		if err = rows.Scan(&categoryID, &count); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan match count: %v\n", err)
+			return nil, cerr.NewInternal()
+		}
+		counts[categoryID] = count
+	}
+	if err = rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading matches table: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return counts, nil
+}
+
+//ListByUser returns the matches of the user that are over, most recent first
+func (m *Match) ListByUser(userID int32, filter *models.MatchFilter) ([]models.Match, cerr.CError) {
+	conn, err := m.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	//NULL parameters disable the corresponding filter
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+
+	rows, err := conn.Query(context.TODO(),
+		`SELECT `+matchColumns+` FROM matches
+		WHERE $1 = ANY(players) AND state NOT IN ($2, $3)
+		AND ($4::int IS NULL OR category_id = $4)
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY created_at DESC OFFSET $7 LIMIT $8`,
+		userID, models.MatchPending, models.MatchActive, filter.CategoryID, from, to, filter.Offset, filter.Limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT matches: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer rows.Close()
+
+	matches := make([]models.Match, 0)
+	for rows.Next() {
+		match, err := scanMatch(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan match: %v\n", err)
+			return nil, cerr.NewInternal()
+		}
+		matches = append(matches, *match)
+	}
+	if err = rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading matches table: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return matches, nil
+}
+
+func scanMatch(row pgx.Row) (*models.Match, error) {
+	var match models.Match
+	err := row.Scan(&match.Id, &match.CategoryID, &match.Players, &match.Rules, &match.State, &match.CreatedAt,
+		&match.StartedAt, &match.EndsAt, &match.EndedAt, &match.Outcome, &match.WinnerID, &match.Reports,
+		&match.RoundScores, &match.RatingChanges)
+	if err != nil {
+		return nil, err
+	}
+
+	return &match, nil
+}
@@ -0,0 +1,109 @@
+package pgRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	cerr "mmr/errors"
+	"mmr/models"
+	"os"
+)
+
+const ratingColumns = "user_id, category_id, rating, deviation, volatility, reset_season"
+
+type Rating struct {
+	p *pgxpool.Pool
+}
+
+func NewRating(p *pgxpool.Pool) *Rating {
+	return &Rating{
+		p: p,
+	}
+}
+
+func (rt *Rating) Get(userID, categoryID int32) (*models.Rating, cerr.CError) {
+	conn, err := rt.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(),
+		"SELECT "+ratingColumns+" FROM ratings WHERE user_id = $1 AND category_id = $2", userID, categoryID)
+
+	var rating models.Rating
+	if err = row.Scan(&rating.UserID, &rating.CategoryID, &rating.Rating, &rating.Deviation, &rating.Volatility,
+		&rating.ResetSeason); err == pgx.ErrNoRows {
+		return nil, cerr.NewNotFound("rating")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT rating: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return &rating, nil
+}
+
+func (rt *Rating) Set(rating *models.Rating) cerr.CError {
+	conn, err := rt.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(context.TODO(),
+		`INSERT INTO ratings(`+ratingColumns+`) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, category_id) DO UPDATE
+		SET rating = EXCLUDED.rating, deviation = EXCLUDED.deviation, volatility = EXCLUDED.volatility,
+		reset_season = EXCLUDED.reset_season`,
+		rating.UserID, rating.CategoryID, rating.Rating, rating.Deviation, rating.Volatility, rating.ResetSeason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to UPSERT rating: %v\n", err)
+		return cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func (rt *Rating) ListByUser(userID int32) ([]models.Rating, cerr.CError) {
+	return rt.list("SELECT "+ratingColumns+" FROM ratings WHERE user_id = $1", userID)
+}
+
+func (rt *Rating) ListAll() ([]models.Rating, cerr.CError) {
+	return rt.list("SELECT " + ratingColumns + " FROM ratings")
+}
+
+func (rt *Rating) list(query string, args ...interface{}) ([]models.Rating, cerr.CError) {
+	conn, err := rt.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.TODO(), query, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT ratings: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer rows.Close()
+
+	ratings := make([]models.Rating, 0)
+	for rows.Next() {
+		var rating models.Rating
+		if err = rows.Scan(&rating.UserID, &rating.CategoryID, &rating.Rating, &rating.Deviation, &rating.Volatility,
+			&rating.ResetSeason); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan rating: %v\n", err)
+			return nil, cerr.NewInternal()
+		}
+		ratings = append(ratings, rating)
+	}
+	if err = rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading ratings table: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return ratings, nil
+}
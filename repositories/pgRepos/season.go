@@ -0,0 +1,206 @@
+package pgRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	cerr "mmr/errors"
+	"mmr/models"
+	"os"
+)
+
+type Season struct {
+	p *pgxpool.Pool
+}
+
+func NewSeason(p *pgxpool.Pool) *Season {
+	return &Season{
+		p: p,
+	}
+}
+
+func (s *Season) Create(season *models.Season) (int32, cerr.CError) {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return 0, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	row := conn.QueryRow(context.TODO(),
+		`INSERT INTO seasons(name, starts_at, ends_at, closed, ratings_reset) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		season.Name, season.StartsAt, season.EndsAt, season.Closed, season.RatingsReset)
+	if err = row.Scan(&season.Id); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to INSERT season: %v\n", err)
+		return 0, cerr.NewInternal()
+	}
+
+	return season.Id, nil
+}
+
+func (s *Season) Update(season *models.Season) cerr.CError {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(context.TODO(),
+		"UPDATE seasons SET name = $2, starts_at = $3, ends_at = $4, closed = $5, ratings_reset = $6 WHERE id = $1",
+		season.Id, season.Name, season.StartsAt, season.EndsAt, season.Closed, season.RatingsReset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to UPDATE season: %v\n", err)
+		return cerr.NewInternal()
+	}
+	if tag.RowsAffected() == 0 {
+		return cerr.NewNotFound("season")
+	}
+
+	return nil
+}
+
+func (s *Season) Get(id int32) (*models.Season, cerr.CError) {
+	return s.queryOne("SELECT id, name, starts_at, ends_at, closed, ratings_reset FROM seasons WHERE id = $1", id)
+}
+
+//Current returns the season that hasn't been closed yet
+func (s *Season) Current() (*models.Season, cerr.CError) {
+	return s.queryOne("SELECT id, name, starts_at, ends_at, closed, ratings_reset FROM seasons WHERE NOT closed LIMIT 1")
+}
+
+//List returns all seasons, most recent first
+func (s *Season) List() ([]models.Season, cerr.CError) {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.TODO(),
+		"SELECT id, name, starts_at, ends_at, closed, ratings_reset FROM seasons ORDER BY starts_at DESC")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT seasons: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer rows.Close()
+
+	seasons := make([]models.Season, 0)
+	for rows.Next() {
+		var season models.Season
+		if err = rows.Scan(&season.Id, &season.Name, &season.StartsAt, &season.EndsAt, &season.Closed,
+			&season.RatingsReset); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan season: %v\n", err)
+			return nil, cerr.NewInternal()
+		}
+		seasons = append(seasons, season)
+	}
+	if err = rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading seasons table: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return seasons, nil
+}
+
+//SetStandings replaces the standings of the season in a single transaction
+func (s *Season) SetStandings(seasonID int32, standings []models.SeasonStanding) cerr.CError {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to begin a transaction: %v\n", err)
+		return cerr.NewInternal()
+	}
+	defer tx.Rollback(context.TODO())
+
+	if _, err = tx.Exec(context.TODO(), "DELETE FROM season_standings WHERE season_id = $1", seasonID); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to DELETE season standings: %v\n", err)
+		return cerr.NewInternal()
+	}
+	_, err = tx.CopyFrom(context.TODO(), pgx.Identifier{"season_standings"},
+		[]string{"season_id", "category_id", "user_id", "rank", "rating", "deviation"},
+		pgx.CopyFromSlice(len(standings), func(i int) ([]interface{}, error) {
+			st := standings[i]
+			return []interface{}{seasonID, st.CategoryID, st.UserID, st.Rank, st.Rating, st.Deviation}, nil
+		}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to COPY season standings: %v\n", err)
+		return cerr.NewInternal()
+	}
+	if err = tx.Commit(context.TODO()); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to commit season standings: %v\n", err)
+		return cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//ListStandings returns up to limit standings of the category starting at offset, best rank first
+func (s *Season) ListStandings(seasonID, categoryID int32, offset, limit int64) ([]models.SeasonStanding, cerr.CError) {
+	return s.listStandings(
+		`SELECT season_id, category_id, user_id, rank, rating, deviation FROM season_standings
+		WHERE season_id = $1 AND category_id = $2 ORDER BY rank OFFSET $3 LIMIT $4`,
+		seasonID, categoryID, offset, limit)
+}
+
+func (s *Season) listStandings(query string, args ...interface{}) ([]models.SeasonStanding, cerr.CError) {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	rows, err := conn.Query(context.TODO(), query, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT season standings: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer rows.Close()
+
+	standings := make([]models.SeasonStanding, 0)
+	for rows.Next() {
+		var st models.SeasonStanding
+		if err = rows.Scan(&st.SeasonID, &st.CategoryID, &st.UserID, &st.Rank, &st.Rating, &st.Deviation); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan season standing: %v\n", err)
+			return nil, cerr.NewInternal()
+		}
+		standings = append(standings, st)
+	}
+	if err = rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error while reading season_standings table: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return standings, nil
+}
+
+func (s *Season) queryOne(query string, args ...interface{}) (*models.Season, cerr.CError) {
+	conn, err := s.p.Acquire(context.TODO())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to acquire a database connection: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	var season models.Season
+	row := conn.QueryRow(context.TODO(), query, args...)
+	if err = row.Scan(&season.Id, &season.Name, &season.StartsAt, &season.EndsAt, &season.Closed,
+		&season.RatingsReset); err == pgx.ErrNoRows {
+		return nil, cerr.NewNotFound("season")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to SELECT season: %v\n", err)
+		return nil, cerr.NewInternal()
+	}
+
+	return &season, nil
+}
@@ -13,6 +13,8 @@ import (
 	"os"
 )
 
+const userColumns = "id, name, email, pass, verified, roles, totp_secret, totp_enabled, totp_last_step, recovery_codes"
+
 type User struct {
 	p *pgxpool.Pool
 }
@@ -31,7 +33,10 @@ func (usr *User) Create(user *models.User) (int32, cerr.CError) {
 	defer conn.Release()
 
 	row := conn.QueryRow(context.TODO(),
-		"INSERT INTO users(name, email, pass) VALUES ($1, $2, $3) RETURNING id", user.Name, user.Email, user.Pass)
+		`INSERT INTO users(name, email, pass, verified, roles, totp_secret, totp_enabled, totp_last_step, recovery_codes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		user.Name, user.Email, user.Pass, user.Verified, user.Roles, user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep,
+		user.RecoveryCodes)
 	var userID int32
 	if err = row.Scan(&userID); err != nil {
 		var pgErr *pgconn.PgError
@@ -54,15 +59,15 @@ func (usr *User) FindById(userID int32) (*models.User, cerr.CError) {
 	defer conn.Release()
 
 	row := conn.QueryRow(context.TODO(),
-		"SELECT id, name, email, pass FROM users WHERE id = $1", userID)
-	var dbUsr models.User
-	if err = row.Scan(&dbUsr.Id, &dbUsr.Name, &dbUsr.Email, &dbUsr.Pass); err == pgx.ErrNoRows {
+		"SELECT "+userColumns+" FROM users WHERE id = $1", userID)
+	dbUsr, err := scanUser(row)
+	if err == pgx.ErrNoRows {
 		return nil, cerr.NewNotFound("user")
 	} else if err != nil {
 		return nil, cerr.NewInternal()
 	}
 
-	return &dbUsr, nil
+	return dbUsr, nil
 }
 
 func (usr *User) FindByEmail(email string) (*models.User, cerr.CError) {
@@ -73,13 +78,79 @@ func (usr *User) FindByEmail(email string) (*models.User, cerr.CError) {
 	defer conn.Release()
 
 	row := conn.QueryRow(context.TODO(),
-		"SELECT id, name, email, pass FROM users WHERE email = $1", email)
-	var dbUsr models.User
-	if err = row.Scan(&dbUsr.Id, &dbUsr.Name, &dbUsr.Email, &dbUsr.Pass); err == pgx.ErrNoRows {
+		"SELECT "+userColumns+" FROM users WHERE email = $1", email)
+	dbUsr, err := scanUser(row)
+	if err == pgx.ErrNoRows {
 		return nil, cerr.NewNotFound("email")
 	} else if err != nil {
 		return nil, cerr.NewInternal()
 	}
 
+	return dbUsr, nil
+}
+
+func (usr *User) Update(user *models.User) cerr.CError {
+	conn, err := usr.p.Acquire(context.TODO())
+	if err != nil {
+		return cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(context.TODO(),
+		`UPDATE users SET name = $2, email = $3, pass = $4, verified = $5, roles = $6, totp_secret = $7,
+		totp_enabled = $8, totp_last_step = $9, recovery_codes = $10 WHERE id = $1`,
+		user.Id, user.Name, user.Email, user.Pass, user.Verified, user.Roles, user.TOTPSecret, user.TOTPEnabled,
+		user.TOTPLastStep, user.RecoveryCodes)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return cerr.NewExists("email")
+		}
+		fmt.Fprintf(os.Stderr, "Unable to UPDATE: %v", err)
+		return cerr.NewInternal()
+	}
+	if tag.RowsAffected() == 0 {
+		return cerr.NewNotFound("user")
+	}
+
+	return nil
+}
+
+func (usr *User) UseTOTPStep(userID int32, step int64) (bool, cerr.CError) {
+	return usr.conditionalUpdate("UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2",
+		userID, step)
+}
+
+func (usr *User) UseRecoveryCode(userID int32, hash string) (bool, cerr.CError) {
+	return usr.conditionalUpdate(
+		"UPDATE users SET recovery_codes = array_remove(recovery_codes, $2) WHERE id = $1 AND $2 = ANY(recovery_codes)",
+		userID, hash)
+}
+
+//conditionalUpdate runs an UPDATE of a single user guarded by its WHERE clause and reports whether it applied
+func (usr *User) conditionalUpdate(query string, args ...interface{}) (bool, cerr.CError) {
+	conn, err := usr.p.Acquire(context.TODO())
+	if err != nil {
+		return false, cerr.NewInternal()
+	}
+	defer conn.Release()
+
+	tag, err := conn.Exec(context.TODO(), query, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to UPDATE: %v", err)
+		return false, cerr.NewInternal()
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var dbUsr models.User
+	err := row.Scan(&dbUsr.Id, &dbUsr.Name, &dbUsr.Email, &dbUsr.Pass, &dbUsr.Verified, &dbUsr.Roles, &dbUsr.TOTPSecret,
+		&dbUsr.TOTPEnabled, &dbUsr.TOTPLastStep, &dbUsr.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &dbUsr, nil
 }
@@ -0,0 +1,197 @@
+package redisRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"sort"
+	"strconv"
+)
+
+type Leaderboard struct {
+	rdb *redis.Client
+}
+
+func NewLeaderboard(rdb *redis.Client) *Leaderboard {
+	return &Leaderboard{
+		rdb: rdb,
+	}
+}
+
+//Set updates the ratings of several users of the category in a single transaction
+func (lb *Leaderboard) Set(categoryID int32, ratings map[int32]float64) Cerr.CError {
+	_, err := lb.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		for userID, rating := range ratings {
+			pipe.ZAdd(context.TODO(), leaderboardKey(categoryID), &redis.Z{
+				Score:  rating,
+				Member: strconv.Itoa(int(userID)),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't update leaderboard in redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//Range returns up to limit entries ranked after the entry after, or from the top if it is nil.
+//Sorted sets order equal scores by member, so users tied on rating are fetched as a whole and ordered by id
+func (lb *Leaderboard) Range(categoryID int32, after *models.LeaderboardEntry, limit int64) ([]models.LeaderboardEntry, Cerr.CError) {
+	key := leaderboardKey(categoryID)
+	entries := make([]models.LeaderboardEntry, 0, limit)
+	max := "+inf"
+	if after != nil {
+		tied, cerr := lb.tied(key, after.Rating)
+		if cerr != nil {
+			return nil, cerr
+		}
+		for _, entry := range tied {
+			if entry.UserID > after.UserID {
+				entries = append(entries, entry)
+			}
+		}
+		max = "(" + formatScore(after.Rating)
+	}
+
+	if int64(len(entries)) < limit {
+		zs, err := lb.rdb.ZRevRangeByScoreWithScores(context.TODO(), key, &redis.ZRangeBy{
+			Max:   max,
+			Min:   "-inf",
+			Count: limit - int64(len(entries)),
+		}).Result()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't get leaderboard from redis: %v", err)
+			return nil, Cerr.NewInternal()
+		}
+		lower, cerr := parseLeaderboardEntries(zs)
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		//the limit may have cut through the users tied on the lowest rating fetched, replace them with all of them
+		if len(lower) > 0 {
+			last := lower[len(lower)-1].Rating
+			for len(lower) > 0 && lower[len(lower)-1].Rating == last {
+				lower = lower[:len(lower)-1]
+			}
+			tied, cerr := lb.tied(key, last)
+			if cerr != nil {
+				return nil, cerr
+			}
+			lower = append(lower, tied...)
+		}
+		entries = append(entries, lower...)
+	}
+	if int64(len(entries)) > limit {
+		entries = entries[:limit]
+	}
+
+	if len(entries) > 0 {
+		rank, cerr := lb.rank(key, &entries[0])
+		if cerr != nil {
+			return nil, cerr
+		}
+		for i := range entries {
+			entries[i].Rank = rank + int64(i)
+		}
+	}
+
+	return entries, nil
+}
+
+func (lb *Leaderboard) Rank(categoryID, userID int32) (*models.LeaderboardEntry, Cerr.CError) {
+	key := leaderboardKey(categoryID)
+	rating, err := lb.rdb.ZScore(context.TODO(), key, strconv.Itoa(int(userID))).Result()
+	if err == redis.Nil {
+		return nil, Cerr.NewNotFound("leaderboard entry")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get leaderboard rating from redis: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	entry := &models.LeaderboardEntry{
+		UserID: userID,
+		Rating: rating,
+	}
+	rank, cerr := lb.rank(key, entry)
+	if cerr != nil {
+		return nil, cerr
+	}
+	entry.Rank = rank
+
+	return entry, nil
+}
+
+//rank counts the users rated higher than entry and those tied with it that have a lower id
+func (lb *Leaderboard) rank(key string, entry *models.LeaderboardEntry) (int64, Cerr.CError) {
+	higher, err := lb.rdb.ZCount(context.TODO(), key, "("+formatScore(entry.Rating), "+inf").Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get leaderboard rank from redis: %v", err)
+		return 0, Cerr.NewInternal()
+	}
+	tied, cerr := lb.tied(key, entry.Rating)
+	if cerr != nil {
+		return 0, cerr
+	}
+
+	rank := higher + 1
+	for _, t := range tied {
+		if t.UserID < entry.UserID {
+			rank++
+		}
+	}
+	return rank, nil
+}
+
+//tied returns the users rated exactly rating, by ascending id
+func (lb *Leaderboard) tied(key string, rating float64) ([]models.LeaderboardEntry, Cerr.CError) {
+	zs, err := lb.rdb.ZRangeByScoreWithScores(context.TODO(), key, &redis.ZRangeBy{
+		Min: formatScore(rating),
+		Max: formatScore(rating),
+	}).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get leaderboard from redis: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	entries, cerr := parseLeaderboardEntries(zs)
+	if cerr != nil {
+		return nil, cerr
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UserID < entries[j].UserID
+	})
+	return entries, nil
+}
+
+func parseLeaderboardEntries(zs []redis.Z) ([]models.LeaderboardEntry, Cerr.CError) {
+	entries := make([]models.LeaderboardEntry, 0, len(zs))
+	for _, z := range zs {
+		member, _ := z.Member.(string)
+		userID, err := strconv.ParseInt(member, 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't convert redis str to int32: %v", err)
+			return nil, Cerr.NewInternal()
+		}
+		entries = append(entries, models.LeaderboardEntry{
+			UserID: int32(userID),
+			Rating: z.Score,
+		})
+	}
+
+	return entries, nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func leaderboardKey(categoryID int32) string {
+	return fmt.Sprintf("leaderboard:%d", categoryID)
+}
@@ -0,0 +1,110 @@
+package redisRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"strconv"
+	"time"
+)
+
+type OneTimeToken struct {
+	rdb *redis.Client
+}
+
+func NewOneTimeToken(rdb *redis.Client) *OneTimeToken {
+	return &OneTimeToken{
+		rdb: rdb,
+	}
+}
+
+func (ott *OneTimeToken) Set(token *models.OneTimeToken, exp time.Duration) Cerr.CError {
+	key := oneTimeTokenKey(token.Purpose, token.Hash)
+	_, err := ott.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.TODO(), key,
+			"user_id", strconv.Itoa(int(token.UserID)),
+			"email", token.Email,
+			"data", token.Data,
+			"expires_at", token.ExpiresAt.Unix())
+		pipe.Expire(context.TODO(), key, exp)
+		//tokens of a purpose live equally long, so the index lives as long as the last one issued
+		pipe.SAdd(context.TODO(), oneTimeTokenUserKey(token.Purpose, token.UserID), token.Hash)
+		pipe.Expire(context.TODO(), oneTimeTokenUserKey(token.Purpose, token.UserID), exp)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't insert one-time token into redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func (ott *OneTimeToken) Take(purpose, hash string) (*models.OneTimeToken, Cerr.CError) {
+	key := oneTimeTokenKey(purpose, hash)
+	var get *redis.StringStringMapCmd
+	//read and delete in one transaction, so that the token can't be taken twice
+	_, err := ott.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		get = pipe.HGetAll(context.TODO(), key)
+		pipe.Del(context.TODO(), key)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't take one-time token from redis: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	fields := get.Val()
+	if len(fields) == 0 {
+		return nil, Cerr.NewUnauthorized("token")
+	}
+
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't convert redis str to int32: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+	expiresAt, _ := strconv.ParseInt(fields["expires_at"], 10, 64)
+
+	return &models.OneTimeToken{
+		Hash:      hash,
+		Purpose:   purpose,
+		UserID:    int32(userID),
+		Email:     fields["email"],
+		Data:      fields["data"],
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}, nil
+}
+
+func (ott *OneTimeToken) DelUser(purpose string, userID int32) Cerr.CError {
+	userKey := oneTimeTokenUserKey(purpose, userID)
+	hashes, err := ott.rdb.SMembers(context.TODO(), userKey).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get one-time tokens of user from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	keys := make([]string, 0, len(hashes)+1)
+	for _, hash := range hashes {
+		keys = append(keys, oneTimeTokenKey(purpose, hash))
+	}
+	keys = append(keys, userKey)
+	if err = ott.rdb.Del(context.TODO(), keys...).Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't delete one-time tokens of user from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func oneTimeTokenKey(purpose, hash string) string {
+	return "onetime:" + purpose + ":" + hash
+}
+
+//oneTimeTokenUserKey indexes the hashes of the tokens of purpose issued to the user
+func oneTimeTokenUserKey(purpose string, userID int32) string {
+	return "onetime:" + purpose + ":user:" + strconv.Itoa(int(userID))
+}
@@ -0,0 +1,103 @@
+package redisRepos
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"strconv"
+	"time"
+)
+
+//throttleRetries bounds how often a reservation is retried when another one changes the same key concurrently
+const throttleRetries = 10
+
+//releaseScript decrements the count of a key, unless it expired
+var releaseScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], "count") == 1 then
+	return redis.call("HINCRBY", KEYS[1], "count", -1)
+end
+return 0`)
+
+type Throttle struct {
+	rdb *redis.Client
+}
+
+func NewThrottle(rdb *redis.Client) *Throttle {
+	return &Throttle{
+		rdb: rdb,
+	}
+}
+
+//Reserve watches the key so that the attempt is only recorded if no other one was in the meantime
+func (t *Throttle) Reserve(key string, at time.Time, window time.Duration,
+	delay func(attempts int64) time.Duration) (time.Duration, Cerr.CError) {
+	var wait time.Duration
+	reserve := func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(context.TODO(), throttleKey(key)).Result()
+		if err != nil {
+			return err
+		}
+		attempts := parseFailedAttempts(fields)
+		if wait = attempts.Last.Add(delay(attempts.Count)).Sub(at); wait > 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(context.TODO(), throttleKey(key), "count", 1)
+			pipe.HSet(context.TODO(), throttleKey(key), "last", at.UnixNano())
+			pipe.Expire(context.TODO(), throttleKey(key), window)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < throttleRetries; i++ {
+		err := t.rdb.Watch(context.TODO(), reserve, throttleKey(key))
+		if err == redis.TxFailedErr {
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't reserve attempt in redis: %v", err)
+			return 0, Cerr.NewInternal()
+		}
+		return wait, nil
+	}
+
+	//the key is hammered by concurrent attempts, have this one back off
+	return time.Second, nil
+}
+
+func (t *Throttle) Release(key string) Cerr.CError {
+	if err := releaseScript.Run(context.TODO(), t.rdb, []string{throttleKey(key)}).Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't release attempt in redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func (t *Throttle) Reset(key string) Cerr.CError {
+	if err := t.rdb.Del(context.TODO(), throttleKey(key)).Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't reset failed attempts in redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func parseFailedAttempts(fields map[string]string) *models.FailedAttempts {
+	count, _ := strconv.ParseInt(fields["count"], 10, 64)
+	last, _ := strconv.ParseInt(fields["last"], 10, 64)
+
+	attempts := &models.FailedAttempts{Count: count}
+	if last != 0 {
+		attempts.Last = time.Unix(0, last)
+	}
+	return attempts
+}
+
+func throttleKey(key string) string {
+	return "throttle:" + key
+}
@@ -5,11 +5,22 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	Cerr "mmr/errors"
+	"mmr/models"
 	"os"
 	"strconv"
 	"time"
 )
 
+//useScript atomically returns the token hash as it was and marks it as used
+var useScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local token = redis.call('HGETALL', KEYS[1])
+redis.call('HSET', KEYS[1], 'used', '1')
+return token
+`)
+
 type Token struct {
 	rdb *redis.Client
 }
@@ -20,26 +31,33 @@ func NewToken(rdb *redis.Client) *Token {
 	}
 }
 
-func (t *Token) Get(uuid string) (int32, Cerr.CError) {
-	userIDStr, err := t.rdb.Get(context.TODO(), uuid).Result()
-	if err == redis.Nil {
-		return -1, Cerr.NewUnauthorized("token")
-	} else if err != nil {
+func (t *Token) Get(uuid string) (*models.Token, Cerr.CError) {
+	fields, err := t.rdb.HGetAll(context.TODO(), tokenKey(uuid)).Result()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't get token from redis: %v", err)
-		return -1, Cerr.NewInternal()
+		return nil, Cerr.NewInternal()
 	}
-
-	userID, err := strconv.ParseInt(userIDStr, 10, 32)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't convert redis str to int32: %v", err)
-		return -1, Cerr.NewInternal()
+	if len(fields) == 0 {
+		return nil, Cerr.NewUnauthorized("token")
 	}
 
-	return int32(userID), nil
+	return parseToken(uuid, fields)
 }
 
-func (t *Token) Set(uuid string, userID int32, exp time.Duration) Cerr.CError {
-	err := t.rdb.Set(context.TODO(), uuid, strconv.Itoa(int(userID)), exp).Err()
+func (t *Token) Set(token *models.Token, exp time.Duration) Cerr.CError {
+	_, err := t.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.TODO(), tokenKey(token.UUID),
+			"user_id", strconv.Itoa(int(token.UserID)),
+			"family", token.Family,
+			"type", token.Type,
+			"pair", token.Pair,
+			"used", boolToStr(token.Used))
+		pipe.Expire(context.TODO(), tokenKey(token.UUID), exp)
+		pipe.SAdd(context.TODO(), familyKey(token.Family), token.UUID)
+		//the family lives as long as its longest lived token, which is always the latest refresh token
+		pipe.Expire(context.TODO(), familyKey(token.Family), exp)
+		return nil
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't insert token into redis: %v", err)
 		return Cerr.NewInternal()
@@ -48,14 +66,220 @@ func (t *Token) Set(uuid string, userID int32, exp time.Duration) Cerr.CError {
 	return nil
 }
 
+//Use marks the token as used and returns it as it was before, so that callers can detect reuse
+func (t *Token) Use(uuid string) (*models.Token, Cerr.CError) {
+	res, err := useScript.Run(context.TODO(), t.rdb, []string{tokenKey(uuid)}).Result()
+	if err == redis.Nil {
+		return nil, Cerr.NewUnauthorized("token")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't mark token as used in redis: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	//HGETALL replies with a flat list of field, value pairs
+	list, _ := res.([]interface{})
+	fields := make(map[string]string, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		field, _ := list[i].(string)
+		value, _ := list[i+1].(string)
+		fields[field] = value
+	}
+
+	return parseToken(uuid, fields)
+}
+
 func (t *Token) Del(uuid string) Cerr.CError {
-	deleted, err := t.rdb.Del(context.TODO(), uuid).Result()
-	if deleted == 0 {
+	family, err := t.rdb.HGet(context.TODO(), tokenKey(uuid), "family").Result()
+	if err == redis.Nil {
 		return Cerr.NewUnauthorized("token")
 	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get token from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	_, err = t.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.TODO(), tokenKey(uuid))
+		pipe.SRem(context.TODO(), familyKey(family), uuid)
+		return nil
+	})
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't delete token from redis: %v", err)
 		return Cerr.NewInternal()
 	}
 
 	return nil
 }
+
+//DelFamily removes every token of the family
+func (t *Token) DelFamily(family string) Cerr.CError {
+	uuids, err := t.rdb.SMembers(context.TODO(), familyKey(family)).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get token family from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	userID, err := t.rdb.HGet(context.TODO(), sessionKey(family), "user_id").Result()
+	if err != nil && err != redis.Nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get session from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	keys := make([]string, 0, len(uuids)+2)
+	for _, uuid := range uuids {
+		keys = append(keys, tokenKey(uuid))
+	}
+	keys = append(keys, familyKey(family), sessionKey(family))
+
+	_, err = t.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.TODO(), keys...)
+		if userID != "" {
+			pipe.SRem(context.TODO(), userSessionsKey(userID), family)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't delete token family from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//SetSession stores the session of a family, it expires after exp
+func (t *Token) SetSession(session *models.Session, exp time.Duration) Cerr.CError {
+	userID := strconv.Itoa(int(session.UserID))
+	_, err := t.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.TODO(), sessionKey(session.Id),
+			"user_id", userID,
+			"created_at", session.CreatedAt.Unix(),
+			"last_used_at", session.LastUsedAt.Unix(),
+			"user_agent", session.UserAgent,
+			"ip", session.IP)
+		pipe.Expire(context.TODO(), sessionKey(session.Id), exp)
+		pipe.SAdd(context.TODO(), userSessionsKey(userID), session.Id)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't insert session into redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//TouchSession updates the last use of the session and extends it by exp
+func (t *Token) TouchSession(family string, lastUsed time.Time, exp time.Duration) Cerr.CError {
+	exists, err := t.rdb.Exists(context.TODO(), sessionKey(family)).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get session from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+	if exists == 0 {
+		return Cerr.NewNotFound("session")
+	}
+
+	_, err = t.rdb.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.HSet(context.TODO(), sessionKey(family), "last_used_at", lastUsed.Unix())
+		pipe.Expire(context.TODO(), sessionKey(family), exp)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't update session in redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+func (t *Token) ListSessions(userID int32) ([]models.Session, Cerr.CError) {
+	key := userSessionsKey(strconv.Itoa(int(userID)))
+	families, err := t.rdb.SMembers(context.TODO(), key).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get sessions from redis: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	sessions := make([]models.Session, 0, len(families))
+	for _, family := range families {
+		fields, err := t.rdb.HGetAll(context.TODO(), sessionKey(family)).Result()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't get session from redis: %v", err)
+			return nil, Cerr.NewInternal()
+		}
+		//the session has expired, forget about it
+		if len(fields) == 0 {
+			_ = t.rdb.SRem(context.TODO(), key, family).Err()
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+		lastUsedAt, _ := strconv.ParseInt(fields["last_used_at"], 10, 64)
+		sessions = append(sessions, models.Session{
+			Id:         family,
+			UserID:     userID,
+			CreatedAt:  time.Unix(createdAt, 0),
+			LastUsedAt: time.Unix(lastUsedAt, 0),
+			UserAgent:  fields["user_agent"],
+			IP:         fields["ip"],
+		})
+	}
+
+	return sessions, nil
+}
+
+//DelUser removes every token of every family of the user
+func (t *Token) DelUser(userID int32) Cerr.CError {
+	families, err := t.rdb.SMembers(context.TODO(), userSessionsKey(strconv.Itoa(int(userID)))).Result()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't get sessions from redis: %v", err)
+		return Cerr.NewInternal()
+	}
+
+	for _, family := range families {
+		if cerr := t.DelFamily(family); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+func parseToken(uuid string, fields map[string]string) (*models.Token, Cerr.CError) {
+	userID, err := strconv.ParseInt(fields["user_id"], 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't convert redis str to int32: %v", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	return &models.Token{
+		UUID:   uuid,
+		UserID: int32(userID),
+		Family: fields["family"],
+		Type:   fields["type"],
+		Pair:   fields["pair"],
+		Used:   fields["used"] == "1",
+	}, nil
+}
+
+func boolToStr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func tokenKey(uuid string) string {
+	return "token:" + uuid
+}
+
+func familyKey(family string) string {
+	return "family:" + family
+}
+
+func sessionKey(family string) string {
+	return "session:" + family
+}
+
+func userSessionsKey(userID string) string {
+	return "user_sessions:" + userID
+}
@@ -7,40 +7,86 @@ import (
 	Cerr "mmr/errors"
 	"mmr/models"
 	"os"
+	"sort"
 	"time"
 )
 
+//token types, stored in the typ claim
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+const (
+	accessTokenTTL  = time.Minute * 15
+	refreshTokenTTL = time.Hour * 24 * 7
+	//MaxTokenTTL is the lifetime of the longest lived token, a retired signing key must verify tokens at least that long
+	MaxTokenTTL = refreshTokenTTL
+)
+
 type tokenPair struct {
 	at *tokenDetails
 	rt *tokenDetails
 }
 
 type tokenDetails struct {
-	token  string
-	uuid   string
-	userID int32
-	exp    int64
+	token     string
+	uuid      string
+	tokenType string
+	userID    int32
+	exp       int64
 }
 
 type TokenRepository interface {
-	Get(key string) (int32, Cerr.CError)
-	Set(key string, val int32, exp time.Duration) Cerr.CError
-	Del(key string) Cerr.CError
+	Get(uuid string) (*models.Token, Cerr.CError)
+	Set(token *models.Token, exp time.Duration) Cerr.CError
+	Use(uuid string) (*models.Token, Cerr.CError)
+	Del(uuid string) Cerr.CError
+	DelFamily(family string) Cerr.CError
+	SetSession(session *models.Session, exp time.Duration) Cerr.CError
+	TouchSession(family string, lastUsed time.Time, exp time.Duration) Cerr.CError
+	ListSessions(userID int32) ([]models.Session, Cerr.CError)
+	DelUser(userID int32) Cerr.CError
+}
+
+//TokenSigner signs jwts, setting the kid header of the key used
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
 }
 
+//RegisterHook is called with every newly registered user
+type RegisterHook func(usr *models.User)
+
 type Auth struct {
-	usrRepo   UserRepository
-	tokenRepo TokenRepository
+	usrRepo    UserRepository
+	tokenRepo  TokenRepository
+	signer     TokenSigner
+	twoFactor  *TwoFactor
+	throttle   *Throttle
+	onRegister []RegisterHook
 }
 
-func NewAuth(usrRepo UserRepository, tokenRepo TokenRepository) *Auth {
+func NewAuth(usrRepo UserRepository, tokenRepo TokenRepository, signer TokenSigner, twoFactor *TwoFactor,
+	throttle *Throttle) *Auth {
 	return &Auth{
 		usrRepo:   usrRepo,
 		tokenRepo: tokenRepo,
+		signer:    signer,
+		twoFactor: twoFactor,
+		throttle:  throttle,
 	}
 }
 
-func (auth *Auth) Register(usr *models.User) (string, string, Cerr.CError) {
+//OnRegister registers fn to be called every time a user registers
+func (auth *Auth) OnRegister(fn RegisterHook) {
+	auth.onRegister = append(auth.onRegister, fn)
+}
+
+//Register creates the user, unverified, and starts a session described by session
+func (auth *Auth) Register(usr *models.User, session *models.Session) (string, string, Cerr.CError) {
+	usr.Verified = false
+	usr.Roles = nil
+	usr.TOTPEnabled = false
 	if err := usr.HashPass(usr.Pass); err != nil {
 		fmt.Fprintf(os.Stderr, "Can't hash the password: %v\n", err)
 		return "", "", Cerr.NewInternal()
@@ -50,91 +96,217 @@ func (auth *Auth) Register(usr *models.User) (string, string, Cerr.CError) {
 	if cerr != nil {
 		return "", "", cerr
 	}
+	usr.Id = userID
+	auth.registered(usr)
 
-	tp, cerr := genTP()
-	if cerr != nil {
-		return "", "", cerr
-	}
+	return auth.startSession(usr, session)
+}
 
-	if err := storeTP(auth.tokenRepo, userID, tp); err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't store token: %v", err)
-		return "", "", Cerr.NewInternal()
+//Login checks the credentials of the user and starts a session described by session.
+//Users with two-factor authentication enabled get a challenge to complete the login with instead.
+//Failed logins are throttled per account and per IP of the session
+func (auth *Auth) Login(usr *models.User, session *models.Session) (*models.LoginResult, Cerr.CError) {
+	if cerr := auth.throttle.Reserve(usr.Email, session.IP); cerr != nil {
+		return nil, cerr
 	}
 
-	return tp.at.token, tp.rt.token, nil
-}
-
-func (auth *Auth) Login(usr *models.User) (string, string, Cerr.CError) {
 	dbUsr, cerr := auth.usrRepo.FindByEmail(usr.Email)
 	if cerr != nil {
-		return "", "", cerr
+		return nil, cerr
 	}
 
 	if err := dbUsr.ValidatePass(usr.Pass); err != nil {
 		fmt.Fprintf(os.Stderr, "incorrect password : %v\n", err)
-		return "", "", Cerr.NewUnauthorized("password")
+		return nil, Cerr.NewUnauthorized("password")
+	}
+	if cerr = auth.throttle.Succeed(usr.Email, session.IP); cerr != nil {
+		return nil, cerr
 	}
 
-	tp, cerr := genTP()
+	return auth.authenticated(dbUsr, session)
+}
+
+//CompleteLogin answers the challenge of a login with a TOTP or recovery code and starts a session described by session
+func (auth *Auth) CompleteLogin(challenge, code string, session *models.Session) (string, string, Cerr.CError) {
+	userID, cerr := auth.twoFactor.Answer(challenge, code)
 	if cerr != nil {
 		return "", "", cerr
 	}
 
-	if cerr = storeTP(auth.tokenRepo, dbUsr.Id, tp); cerr != nil {
+	usr, cerr := auth.usrRepo.FindById(userID)
+	if cerr != nil {
 		return "", "", cerr
 	}
 
-	return tp.at.token, tp.rt.token, nil
+	return auth.startSession(usr, session)
 }
 
+//Logout ends the session the token belongs to
 func (auth *Auth) Logout(uuid string) Cerr.CError {
-	cerr := auth.tokenRepo.Del(uuid)
+	token, cerr := auth.tokenRepo.Get(uuid)
 	if cerr != nil {
 		return cerr
 	}
 
-	return nil
+	return auth.tokenRepo.DelFamily(token.Family)
 }
 
-func (auth *Auth) Refresh(uuid string, userID int32) (string, string, Cerr.CError) {
-	//remove refresh token from token repo
-	if cerr := auth.tokenRepo.Del(uuid); cerr != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't delete token from redis: %v", cerr)
+//Refresh exchanges a refresh token for a new pair of the same family, revoking the access token issued with it.
+//A refresh token can only be exchanged once; presenting it again means it has leaked, so the whole family is revoked
+func (auth *Auth) Refresh(uuid, tokenType string, userID int32) (string, string, Cerr.CError) {
+	//an access token must never be traded for a new pair
+	if tokenType != RefreshToken {
+		return "", "", Cerr.NewUnauthorized("token type")
+	}
+
+	token, cerr := auth.tokenRepo.Use(uuid)
+	if cerr != nil {
 		return "", "", cerr
 	}
+	if token.Type != RefreshToken || token.UserID != userID {
+		return "", "", Cerr.NewUnauthorized("token")
+	}
+	if token.Used {
+		fmt.Fprintf(os.Stderr, "Refresh token %s reused, revoking family %s\n", uuid, token.Family)
+		if cerr = auth.tokenRepo.DelFamily(token.Family); cerr != nil {
+			return "", "", cerr
+		}
+		return "", "", Cerr.NewUnauthorized("token")
+	}
+
+	//revoke the access token issued together with the refresh token
+	if cerr = auth.tokenRepo.Del(token.Pair); cerr != nil {
+		if _, ok := cerr.(Cerr.Unauthorized); !ok {
+			return "", "", cerr
+		}
+	}
 
-	//generate new token pair
-	tp, cerr := genTP()
+	//generate new token pair, with the roles the user has now
+	usr, cerr := auth.usrRepo.FindById(userID)
+	if cerr != nil {
+		return "", "", cerr
+	}
+	tp, cerr := auth.genTP(usr.Roles)
 	if cerr != nil {
 		return "", "", cerr
 	}
 
-	//store new token pair in token repo
-	if cerr = storeTP(auth.tokenRepo, userID, tp); cerr != nil {
+	//store new token pair in the same family
+	if cerr = storeTP(auth.tokenRepo, userID, token.Family, tp); cerr != nil {
 		return "", "", cerr
 	}
 
+	if cerr = auth.tokenRepo.TouchSession(token.Family, time.Now(), refreshTokenTTL); cerr != nil {
+		if _, ok := cerr.(Cerr.NotFound); !ok {
+			return "", "", cerr
+		}
+	}
+
 	return tp.at.token, tp.rt.token, nil
 }
 
-func (auth *Auth) GetUserID(uuid string) (int32, Cerr.CError) {
-	userID, cerr := auth.tokenRepo.Get(uuid)
+//Sessions lists the sessions of the user, flagging the one of family current
+func (auth *Auth) Sessions(userID int32, current string) ([]models.Session, Cerr.CError) {
+	sessions, cerr := auth.tokenRepo.ListSessions(userID)
 	if cerr != nil {
-		return -1, cerr
+		return nil, cerr
 	}
 
-	return userID, nil
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == current
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
 }
 
-func genTP() (*tokenPair, Cerr.CError) {
+//RevokeSession ends a session of the user
+func (auth *Auth) RevokeSession(userID int32, id string) Cerr.CError {
+	sessions, cerr := auth.tokenRepo.ListSessions(userID)
+	if cerr != nil {
+		return cerr
+	}
+
+	for _, session := range sessions {
+		if session.Id == id {
+			return auth.tokenRepo.DelFamily(id)
+		}
+	}
+
+	return Cerr.NewNotFound("session")
+}
+
+//LogoutAll ends every session of the user
+func (auth *Auth) LogoutAll(userID int32) Cerr.CError {
+	return auth.tokenRepo.DelUser(userID)
+}
+
+//GetToken returns the stored state of the token. This is mainly for checking if the token is still valid
+func (auth *Auth) GetToken(uuid string) (*models.Token, Cerr.CError) {
+	return auth.tokenRepo.Get(uuid)
+}
+
+//authenticated finishes the login of a user who proved who they are, see Login
+func (auth *Auth) authenticated(usr *models.User, session *models.Session) (*models.LoginResult, Cerr.CError) {
+	if usr.TOTPEnabled {
+		challenge, cerr := auth.twoFactor.Challenge(usr.Id)
+		if cerr != nil {
+			return nil, cerr
+		}
+		return &models.LoginResult{ChallengeToken: challenge}, nil
+	}
+
+	at, rt, cerr := auth.startSession(usr, session)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return &models.LoginResult{AccessToken: at, RefreshToken: rt}, nil
+}
+
+//registered calls the registration hooks with a newly created user
+func (auth *Auth) registered(usr *models.User) {
+	for _, fn := range auth.onRegister {
+		fn(usr)
+	}
+}
+
+//startSession issues a token pair of a new family to the user and stores session for it
+func (auth *Auth) startSession(usr *models.User, session *models.Session) (string, string, Cerr.CError) {
+	tp, cerr := auth.genTP(usr.Roles)
+	if cerr != nil {
+		return "", "", cerr
+	}
+
+	family := uuid.NewString()
+	if cerr = storeTP(auth.tokenRepo, usr.Id, family, tp); cerr != nil {
+		return "", "", cerr
+	}
+
+	now := time.Now()
+	session.Id = family
+	session.UserID = usr.Id
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	if cerr = auth.tokenRepo.SetSession(session, refreshTokenTTL); cerr != nil {
+		return "", "", cerr
+	}
+
+	return tp.at.token, tp.rt.token, nil
+}
+
+//genTP generates a token pair, the access token carrying the roles of the user
+func (auth *Auth) genTP(roles []string) (*tokenPair, Cerr.CError) {
 	tp := &tokenPair{}
-	at, cerr := genToken(time.Now().Add(time.Minute * 15))
+	at, cerr := auth.genToken(AccessToken, time.Now().Add(accessTokenTTL), jwt.MapClaims{"roles": rolesClaim(roles)})
 	if cerr != nil {
 		return nil, cerr
 	}
 	tp.at = at
 
-	rt, cerr := genToken(time.Now().Add(time.Hour * 24 * 7))
+	rt, cerr := auth.genToken(RefreshToken, time.Now().Add(refreshTokenTTL), nil)
 	if cerr != nil {
 		return nil, cerr
 	}
@@ -143,17 +315,23 @@ func genTP() (*tokenPair, Cerr.CError) {
 	return tp, nil
 }
 
-func genToken(exp time.Time) (*tokenDetails, Cerr.CError) {
+//genToken signs a token of tokenType with extra claims on top of the standard ones
+func (auth *Auth) genToken(tokenType string, exp time.Time, extra jwt.MapClaims) (*tokenDetails, Cerr.CError) {
 	td := &tokenDetails{}
 	td.exp = exp.Unix()
 	td.uuid = uuid.NewString()
+	td.tokenType = tokenType
+
+	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["uuid"] = td.uuid
+	claims["typ"] = td.tokenType
+	claims["exp"] = td.exp
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"uuid": td.uuid,
-		"exp":  td.exp,
-	})
 	var err error
-	td.token, err = token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	td.token, err = auth.signer.Sign(claims)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't get signed jwt: %v", err)
 		return nil, Cerr.NewInternal()
@@ -162,17 +340,40 @@ func genToken(exp time.Time) (*tokenDetails, Cerr.CError) {
 	return td, nil
 }
 
-func storeTP(tokenRepo TokenRepository, userID int32, tp *tokenPair) Cerr.CError {
+//rolesClaim returns the roles of a user including RoleUser, which every user has
+func rolesClaim(roles []string) []string {
+	claim := []string{models.RoleUser}
+	for _, role := range roles {
+		if role != models.RoleUser {
+			claim = append(claim, role)
+		}
+	}
+
+	return claim
+}
+
+func storeTP(tokenRepo TokenRepository, userID int32, family string, tp *tokenPair) Cerr.CError {
 	at := time.Unix(tp.at.exp, 0) //converting Unix to UTC(to Time object)
 	rt := time.Unix(tp.rt.exp, 0)
 	now := time.Now()
 
-	err := tokenRepo.Set(tp.at.uuid, userID, at.Sub(now))
+	err := tokenRepo.Set(&models.Token{
+		UUID:   tp.at.uuid,
+		UserID: userID,
+		Family: family,
+		Type:   tp.at.tokenType,
+	}, at.Sub(now))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't store access token in tokenRepo: %v", err)
 		return Cerr.NewInternal()
 	}
-	err = tokenRepo.Set(tp.rt.uuid, userID, rt.Sub(now))
+	err = tokenRepo.Set(&models.Token{
+		UUID:   tp.rt.uuid,
+		UserID: userID,
+		Family: family,
+		Type:   tp.rt.tokenType,
+		Pair:   tp.at.uuid,
+	}, rt.Sub(now))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't store refresh token in tokenRepo: %v", err)
 		return Cerr.NewInternal()
@@ -1,29 +1,438 @@
 package services
 
 import (
-	cerr "mmr/errors"
+	"golang.org/x/text/language"
+	Cerr "mmr/errors"
 	"mmr/models"
+	"sort"
+	"strings"
 )
 
 type CategoryRepository interface {
-	List() ([]models.Category, cerr.CError)
-	Get(id int32) (*models.Category, cerr.CError)
+	Create(category *models.Category) (int32, Cerr.CError)
+	Update(category *models.Category) Cerr.CError
+	Reorder(ids []int32) Cerr.CError
+	List() ([]models.Category, Cerr.CError)
+	Get(id int32) (*models.Category, Cerr.CError)
 }
 
+const (
+	DefaultCategoryLimit = 50
+	MaxCategoryLimit     = 100
+)
+
+//StatsFunc returns the live activity of every category that has any
+type StatsFunc func() (map[int32]models.CategoryStats, Cerr.CError)
+
 type Category struct {
-	repo CategoryRepository
+	repo  CategoryRepository
+	stats StatsFunc
+	//locale of the names and descriptions of categories, translations are in other locales
+	locale language.Tag
 }
 
-func NewCategory(repo CategoryRepository) *Category {
+func NewCategory(repo CategoryRepository, locale language.Tag) *Category {
 	return &Category{
-		repo: repo,
+		repo:   repo,
+		locale: locale,
+	}
+}
+
+//StatsFrom sets where the live activity of categories in listings comes from
+func (ctg *Category) StatsFrom(fn StatsFunc) {
+	ctg.stats = fn
+}
+
+//List returns a page of the categories users can queue for that pass filter, in the order of filter.Sort.
+//Categories are localized for acceptLanguage, an Accept-Language header, before being filtered and sorted.
+//cursor is the NextCursor of the previous page, or empty for the first page
+func (ctg *Category) List(filter *models.CategoryFilter, cursor string, limit int64,
+	acceptLanguage string) (*models.CategoryPage, Cerr.CError) {
+	offset, cerr := decodeCursor(cursor)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if limit <= 0 {
+		limit = DefaultCategoryLimit
+	} else if limit > MaxCategoryLimit {
+		limit = MaxCategoryLimit
+	}
+	if filter.Sort == "" {
+		filter.Sort = models.CategorySortPosition
+	}
+	less, ok := categorySorts[filter.Sort]
+	if !ok {
+		return nil, Cerr.NewBadRequest("sort")
+	}
+	filter.Tag = normalizeTag(filter.Tag)
+
+	categories, cerr := ctg.active(acceptLanguage)
+	if cerr != nil {
+		return nil, cerr
+	}
+	filtered := categories[:0]
+	for i := range categories {
+		if filter.Matches(&categories[i]) {
+			filtered = append(filtered, categories[i])
+		}
 	}
+	//categories come by position, which breaks ties of the other orders
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return less(&filtered[i], &filtered[j])
+	})
+
+	page := &models.CategoryPage{Categories: make([]models.Category, 0)}
+	if offset < int64(len(filtered)) {
+		page.Categories = filtered[offset:]
+	}
+	if int64(len(page.Categories)) > limit {
+		page.Categories = page.Categories[:limit]
+		page.NextCursor = encodeCursor(offset + limit)
+	}
+
+	return page, nil
+}
+
+//Tree returns the categories users can queue for as a forest, siblings by position, localized for acceptLanguage
+func (ctg *Category) Tree(acceptLanguage string) ([]models.CategoryNode, Cerr.CError) {
+	categories, cerr := ctg.active(acceptLanguage)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return buildTree(categories, nil), nil
 }
 
-func (ctg *Category) List() ([]models.Category, cerr.CError) {
+//ListAll returns every category, archived ones included, by position
+func (ctg *Category) ListAll() ([]models.Category, Cerr.CError) {
 	return ctg.repo.List()
 }
 
-func (ctg *Category) Get(id int32) (*models.Category, cerr.CError) {
-	return ctg.repo.Get(id)
+//Get returns the category localized for acceptLanguage, an Accept-Language header
+func (ctg *Category) Get(id int32, acceptLanguage string) (*models.Category, Cerr.CError) {
+	category, cerr := ctg.repo.Get(id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	ctg.localize(category, preferences(acceptLanguage))
+
+	return category, nil
+}
+
+//Create adds a category with the name, description, translations, parent, tags and rules of category
+//after every other one. Names are unique ignoring case
+func (ctg *Category) Create(category *models.Category) (*models.Category, Cerr.CError) {
+	translations, cerr := ctg.normalizeTranslations(category.Translations)
+	if cerr != nil {
+		return nil, cerr
+	}
+	created := &models.Category{
+		Name:         strings.TrimSpace(category.Name),
+		Description:  strings.TrimSpace(category.Description),
+		Translations: translations,
+		ParentID:     category.ParentID,
+		Tags:         normalizeTags(category.Tags),
+		Rules:        category.Rules,
+	}
+	if cerr := validateCategory(created); cerr != nil {
+		return nil, cerr
+	}
+	if created.ParentID != nil {
+		if _, cerr := ctg.repo.Get(*created.ParentID); cerr != nil {
+			return nil, Cerr.NewBadRequest("parent_id")
+		}
+	}
+
+	if _, cerr := ctg.repo.Create(created); cerr != nil {
+		return nil, cerr
+	}
+
+	return created, nil
+}
+
+//Update sets the name, description, translations, parent, tags and rules of category id to those of changes.
+//A category can't be moved under itself or its subcategories. Running matches keep the rules they started with
+func (ctg *Category) Update(id int32, changes *models.Category) (*models.Category, Cerr.CError) {
+	category, cerr := ctg.repo.Get(id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	translations, cerr := ctg.normalizeTranslations(changes.Translations)
+	if cerr != nil {
+		return nil, cerr
+	}
+	category.Name = strings.TrimSpace(changes.Name)
+	category.Description = strings.TrimSpace(changes.Description)
+	category.Translations = translations
+	category.ParentID = changes.ParentID
+	category.Tags = normalizeTags(changes.Tags)
+	category.Rules = changes.Rules
+	if cerr = validateCategory(category); cerr != nil {
+		return nil, cerr
+	}
+
+	if category.ParentID != nil {
+		ancestors, cerr := categoryAncestors(ctg.repo, *category.ParentID)
+		if _, ok := cerr.(Cerr.NotFound); ok {
+			return nil, Cerr.NewBadRequest("parent_id")
+		} else if cerr != nil {
+			return nil, cerr
+		}
+		for _, ancestor := range ancestors {
+			if ancestor.Id == id {
+				return nil, Cerr.NewBadRequest("parent_id")
+			}
+		}
+	}
+
+	if cerr = ctg.repo.Update(category); cerr != nil {
+		return nil, cerr
+	}
+
+	return category, nil
+}
+
+//Archive hides a category and its subcategories from the listing and closes their queues, or brings them back
+//if archived is false. Nobody can join them anymore and the users waiting are dropped by the next matchmaking Tick.
+//Matches and ratings are kept
+func (ctg *Category) Archive(id int32, archived bool) (*models.Category, Cerr.CError) {
+	category, cerr := ctg.repo.Get(id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	category.Archived = archived
+	if cerr = ctg.repo.Update(category); cerr != nil {
+		return nil, cerr
+	}
+
+	return category, nil
+}
+
+//Reorder sets the order categories are listed in. ids must contain every category exactly once
+func (ctg *Category) Reorder(ids []int32) ([]models.Category, Cerr.CError) {
+	categories, cerr := ctg.repo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+	if len(ids) != len(categories) {
+		return nil, Cerr.NewBadRequest("ids")
+	}
+
+	seen := make(map[int32]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return nil, Cerr.NewBadRequest("ids")
+		}
+		seen[id] = true
+	}
+
+	if cerr = ctg.repo.Reorder(ids); cerr != nil {
+		return nil, cerr
+	}
+
+	return ctg.repo.List()
+}
+
+//active returns the categories users can queue for by position, localized for acceptLanguage
+//and along with their live activity
+func (ctg *Category) active(acceptLanguage string) ([]models.Category, Cerr.CError) {
+	categories, cerr := ctg.repo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+	categories = activeCategories(categories)
+	prefs := preferences(acceptLanguage)
+	for i := range categories {
+		ctg.localize(&categories[i], prefs)
+	}
+	if ctg.stats == nil {
+		return categories, nil
+	}
+
+	stats, cerr := ctg.stats()
+	if cerr != nil {
+		return nil, cerr
+	}
+	for i := range categories {
+		s := stats[categories[i].Id]
+		categories[i].Stats = &s
+	}
+
+	return categories, nil
+}
+
+//localize replaces the name and description of category with the translation that best matches prefs,
+//keeping those of the default locale if none does. Translations are left out of localized categories
+func (ctg *Category) localize(category *models.Category, prefs []language.Tag) {
+	locales := make([]string, 0, len(category.Translations))
+	for locale := range category.Translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+
+	//the default locale goes first, it's what the matcher falls back to
+	supported := []language.Tag{ctg.locale}
+	for _, locale := range locales {
+		supported = append(supported, language.Make(locale))
+	}
+	_, i, _ := language.NewMatcher(supported).Match(prefs...)
+
+	category.Locale = ctg.locale.String()
+	if i > 0 {
+		translation := category.Translations[locales[i-1]]
+		category.Locale = locales[i-1]
+		category.Name = translation.Name
+		if translation.Description != "" {
+			category.Description = translation.Description
+		}
+	}
+	category.Translations = nil
+}
+
+//normalizeTranslations canonicalizes the language tags of translations and trims them.
+//There can't be a translation for the default locale, that's what the name and description are in
+func (ctg *Category) normalizeTranslations(
+	translations map[string]models.CategoryTranslation) (map[string]models.CategoryTranslation, Cerr.CError) {
+	normalized := make(map[string]models.CategoryTranslation, len(translations))
+	for locale, translation := range translations {
+		tag, err := language.Parse(locale)
+		if err != nil || tag == ctg.locale {
+			return nil, Cerr.NewBadRequest("translations")
+		}
+		translation.Name = strings.TrimSpace(translation.Name)
+		translation.Description = strings.TrimSpace(translation.Description)
+		if translation.Name == "" {
+			return nil, Cerr.NewBadRequest("translations")
+		}
+		if _, ok := normalized[tag.String()]; ok {
+			return nil, Cerr.NewBadRequest("translations")
+		}
+		normalized[tag.String()] = translation
+	}
+
+	return normalized, nil
+}
+
+//preferences parses an Accept-Language header, an invalid one doesn't prefer any language
+func preferences(acceptLanguage string) []language.Tag {
+	prefs, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil {
+		return nil
+	}
+	return prefs
+}
+
+//categorySorts reports whether a goes before b in each of the category sort orders
+var categorySorts = map[string]func(a, b *models.Category) bool{
+	//categories are listed by position to begin with
+	models.CategorySortPosition: func(a, b *models.Category) bool {
+		return false
+	},
+	models.CategorySortName: func(a, b *models.Category) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	},
+	models.CategorySortQueued: func(a, b *models.Category) bool {
+		return a.Stats != nil && b.Stats != nil && a.Stats.Queued > b.Stats.Queued
+	},
+	models.CategorySortActive: func(a, b *models.Category) bool {
+		return a.Stats != nil && b.Stats != nil && a.Stats.ActiveMatches > b.Stats.ActiveMatches
+	},
+}
+
+func validateCategory(category *models.Category) Cerr.CError {
+	if category.Name == "" {
+		return Cerr.NewBadRequest("name")
+	}
+	rules := category.Rules
+	if rules.MinRating > 0 && rules.MaxRating > 0 && rules.MaxRating < rules.MinRating {
+		return Cerr.NewBadRequest("max_rating")
+	}
+
+	return nil
+}
+
+//categoryAncestors returns the category id followed by its parent, grandparent and so on
+func categoryAncestors(repo CategoryRepository, id int32) ([]models.Category, Cerr.CError) {
+	ancestors := make([]models.Category, 0)
+	for next := &id; next != nil; {
+		category, cerr := repo.Get(*next)
+		if cerr != nil {
+			return nil, cerr
+		}
+		ancestors = append(ancestors, *category)
+		//guards against cycles, which Update doesn't let happen
+		if len(ancestors) > maxCategoryDepth {
+			break
+		}
+		next = category.ParentID
+	}
+
+	return ancestors, nil
+}
+
+//maxCategoryDepth bounds walks up the category tree
+const maxCategoryDepth = 32
+
+//activeCategories filters out archived categories and the subcategories of archived categories, keeping the order
+func activeCategories(categories []models.Category) []models.Category {
+	byID := make(map[int32]models.Category, len(categories))
+	for _, category := range categories {
+		byID[category.Id] = category
+	}
+
+	active := make([]models.Category, 0, len(categories))
+	for _, category := range categories {
+		archived := category.Archived
+		parentID := category.ParentID
+		for depth := 0; !archived && parentID != nil && depth < maxCategoryDepth; depth++ {
+			parent, ok := byID[*parentID]
+			archived = !ok || parent.Archived
+			parentID = parent.ParentID
+		}
+		if !archived {
+			active = append(active, category)
+		}
+	}
+
+	return active
+}
+
+//buildTree returns the children of parentID, the top level categories if nil, along with their subcategories
+func buildTree(categories []models.Category, parentID *int32) []models.CategoryNode {
+	nodes := make([]models.CategoryNode, 0)
+	for _, category := range categories {
+		if (parentID == nil) != (category.ParentID == nil) || parentID != nil && *parentID != *category.ParentID {
+			continue
+		}
+		nodes = append(nodes, models.CategoryNode{
+			Category: category,
+			Children: buildTree(categories, &category.Id),
+		})
+	}
+
+	return nodes
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+//normalizeTags lowercases tags and drops empty and repeated ones
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" {
+			continue
+		}
+		dup := false
+		for _, t := range normalized {
+			dup = dup || t == tag
+		}
+		if !dup {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
 }
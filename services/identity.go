@@ -0,0 +1,11 @@
+package services
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+)
+
+type IdentityRepository interface {
+	Get(provider, subject string) (*models.Identity, Cerr.CError)
+	Create(identity *models.Identity) Cerr.CError
+}
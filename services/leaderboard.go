@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/base64"
+	"math"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultLeaderboardLimit = 20
+	MaxLeaderboardLimit     = 100
+)
+
+type LeaderboardRepository interface {
+	Set(categoryID int32, ratings map[int32]float64) Cerr.CError
+	//Range returns up to limit entries ranked after the entry after, or from the top if it is nil.
+	//Entries are ordered by rating, highest first, and users with the same rating by ascending id
+	Range(categoryID int32, after *models.LeaderboardEntry, limit int64) ([]models.LeaderboardEntry, Cerr.CError)
+	Rank(categoryID, userID int32) (*models.LeaderboardEntry, Cerr.CError)
+}
+
+type Leaderboard struct {
+	repo    LeaderboardRepository
+	ctgRepo CategoryRepository
+}
+
+func NewLeaderboard(repo LeaderboardRepository, ctgRepo CategoryRepository) *Leaderboard {
+	return &Leaderboard{
+		repo:    repo,
+		ctgRepo: ctgRepo,
+	}
+}
+
+//Get returns a page of the category leaderboard along with the rank of userID.
+//cursor is the NextCursor of the previous page, or empty for the first page. It points at the last entry of
+//the previous page, so that rating changes between pages don't skip or repeat entries
+func (lb *Leaderboard) Get(categoryID, userID int32, cursor string, limit int64) (*models.LeaderboardPage, Cerr.CError) {
+	if _, cerr := lb.ctgRepo.Get(categoryID); cerr != nil {
+		return nil, cerr
+	}
+
+	after, cerr := decodeLeaderboardCursor(cursor)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if limit <= 0 {
+		limit = DefaultLeaderboardLimit
+	} else if limit > MaxLeaderboardLimit {
+		limit = MaxLeaderboardLimit
+	}
+
+	//fetch one extra entry to know whether there is a next page
+	entries, cerr := lb.repo.Range(categoryID, after, limit+1)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	page := &models.LeaderboardPage{Entries: entries}
+	if int64(len(entries)) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = encodeLeaderboardCursor(&entries[limit-1])
+	}
+
+	me, cerr := lb.repo.Rank(categoryID, userID)
+	if _, ok := cerr.(Cerr.NotFound); !ok && cerr != nil {
+		return nil, cerr
+	}
+	page.Me = me
+
+	return page, nil
+}
+
+func encodeLeaderboardCursor(last *models.LeaderboardEntry) string {
+	raw := strconv.FormatFloat(last.Rating, 'g', -1, 64) + ":" + strconv.Itoa(int(last.UserID))
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLeaderboardCursor(cursor string) (*models.LeaderboardEntry, Cerr.CError) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, Cerr.NewBadRequest("cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, Cerr.NewBadRequest("cursor")
+	}
+	rating, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(rating) {
+		return nil, Cerr.NewBadRequest("cursor")
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return nil, Cerr.NewBadRequest("cursor")
+	}
+
+	return &models.LeaderboardEntry{UserID: int32(userID), Rating: rating}, nil
+}
+
+func encodeCursor(offset int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(offset, 10)))
+}
+
+func decodeCursor(cursor string) (int64, Cerr.CError) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, Cerr.NewBadRequest("cursor")
+	}
+	offset, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, Cerr.NewBadRequest("cursor")
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"time"
+)
+
+const (
+	PurposeMagicLink = "magic_link"
+	magicLinkTTL     = 15 * time.Minute
+)
+
+//MagicLink logs users in with single use links mailed to them instead of a password
+type MagicLink struct {
+	auth     *Auth
+	ottRepo  OneTimeTokenRepository
+	throttle *Throttle
+	mailer   Mailer
+	//links in emails point to the frontend served at baseURL
+	baseURL string
+}
+
+func NewMagicLink(auth *Auth, ottRepo OneTimeTokenRepository, throttle *Throttle, mailer Mailer,
+	baseURL string) *MagicLink {
+	return &MagicLink{
+		auth:     auth,
+		ottRepo:  ottRepo,
+		throttle: throttle,
+		mailer:   mailer,
+		baseURL:  baseURL,
+	}
+}
+
+//Request mails a login link to the user with email. Requests are rate limited per address whether or not it is
+//registered, and unknown addresses are ignored silently, so that the endpoint can't be used to find out who is
+func (m *MagicLink) Request(email string) Cerr.CError {
+	if cerr := m.throttle.HitMagicLink(email); cerr != nil {
+		return cerr
+	}
+
+	usr, cerr := m.auth.usrRepo.FindByEmail(email)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		return nil
+	} else if cerr != nil {
+		return cerr
+	}
+
+	secret, cerr := issueOneTimeToken(m.ottRepo, &models.OneTimeToken{
+		Purpose: PurposeMagicLink,
+		UserID:  usr.Id,
+		Email:   usr.Email,
+	}, magicLinkTTL)
+	if cerr != nil {
+		return cerr
+	}
+
+	body := fmt.Sprintf("Follow %s/magic?token=%s within %d minutes to log in.\n\n"+
+		"If you didn't ask to log in, ignore this email.", m.baseURL, secret, int(magicLinkTTL.Minutes()))
+	if err := m.mailer.Send(usr.Email, "Your login link", body); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't send magic link email: %v\n", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//Exchange logs in the user the link was mailed to and starts a session described by session, see Auth.Login.
+//Following the link proves the user owns the email, so it is verified too
+func (m *MagicLink) Exchange(secret string, session *models.Session) (*models.LoginResult, Cerr.CError) {
+	token, cerr := redeemOneTimeToken(m.ottRepo, PurposeMagicLink, secret)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	usr, cerr := m.auth.usrRepo.FindById(token.UserID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if usr.Email != token.Email {
+		return nil, Cerr.NewUnauthorized("token")
+	}
+	if !usr.Verified {
+		usr.Verified = true
+		if cerr = m.auth.usrRepo.Update(usr); cerr != nil {
+			return nil, cerr
+		}
+	}
+
+	return m.auth.authenticated(usr, session)
+}
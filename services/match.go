@@ -0,0 +1,480 @@
+package services
+
+import (
+	"github.com/google/uuid"
+	Cerr "mmr/errors"
+	"mmr/glicko"
+	"mmr/models"
+	"mmr/shared"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMatchHistoryLimit = 20
+	MaxMatchHistoryLimit     = 100
+)
+
+//realtime message types sent to the players of a match
+const (
+	MsgMatchStarted     = "match_started"
+	MsgMatchEnded       = "match_ended"
+	MsgRoundEnded       = "round_ended"
+	MsgPeerDisconnected = "peer_disconnected"
+	MsgPeerReconnected  = "peer_reconnected"
+)
+
+//results a player can report for themselves
+const (
+	ResultWin  = "win"
+	ResultLoss = "loss"
+	ResultDraw = "draw"
+)
+
+var resultScores = map[string]float64{
+	ResultWin:  glicko.Win,
+	ResultLoss: glicko.Loss,
+	ResultDraw: glicko.Draw,
+}
+
+type MatchRepository interface {
+	Get(id string) (*models.Match, Cerr.CError)
+	Set(match *models.Match) Cerr.CError
+	FindCurrent(userID int32) (*models.Match, Cerr.CError)
+	CountInProgress() (map[int32]int64, Cerr.CError)
+	ListByUser(userID int32, filter *models.MatchFilter) ([]models.Match, Cerr.CError)
+}
+
+type Match struct {
+	repo      MatchRepository
+	ratingSvc *Rating
+	notifier  Notifier
+	clock     shared.Clock
+	//how long a player may stay disconnected before the match is abandoned
+	grace time.Duration
+	//abandonment timers of disconnected players
+	timers map[int32]shared.Timer
+	//time limit timers of active matches
+	deadlines map[string]shared.Timer
+	mu        sync.Mutex
+}
+
+func NewMatch(repo MatchRepository, ratingSvc *Rating, notifier Notifier, clock shared.Clock, grace time.Duration) *Match {
+	return &Match{
+		repo:      repo,
+		ratingSvc: ratingSvc,
+		notifier:  notifier,
+		clock:     clock,
+		grace:     grace,
+		timers:    make(map[int32]shared.Timer),
+		deadlines: make(map[string]shared.Timer),
+		mu:        sync.Mutex{},
+	}
+}
+
+//Create stores a pending match of the category between two users and pairs them. The match is played by the rules
+//the category has now. It becomes active through StartIfReady or once both players are connected
+func (ms *Match) Create(category *models.Category, playerA, playerB int32) (*models.Match, Cerr.CError) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	rules := category.Rules
+	rules.MessageTypes = append([]string(nil), rules.MessageTypes...)
+	match := &models.Match{
+		Id:         uuid.NewString(),
+		CategoryID: category.Id,
+		Players:    []int32{playerA, playerB},
+		Rules:      rules,
+		State:      models.MatchPending,
+		CreatedAt:  ms.clock.Now(),
+		Reports:    make(map[int32]float64),
+	}
+	if cerr := ms.repo.Set(match); cerr != nil {
+		return nil, cerr
+	}
+	ms.notifier.Pair(playerA, playerB)
+
+	for _, userID := range match.Players {
+		if !ms.notifier.Connected(userID) {
+			ms.startTimer(match.Id, userID)
+		}
+	}
+
+	return match, nil
+}
+
+//StartIfReady activates the pending match if every player is connected
+func (ms *Match) StartIfReady(id string) Cerr.CError {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	match, cerr := ms.repo.Get(id)
+	if cerr != nil {
+		return cerr
+	}
+
+	return ms.startIfReady(match)
+}
+
+func (ms *Match) Get(id string) (*models.Match, Cerr.CError) {
+	return ms.repo.Get(id)
+}
+
+//CountInProgress returns the number of pending and active matches of each category that has any
+func (ms *Match) CountInProgress() (map[int32]int64, Cerr.CError) {
+	return ms.repo.CountInProgress()
+}
+
+//Current returns the pending or active match of the user
+func (ms *Match) Current(userID int32) (*models.Match, Cerr.CError) {
+	return ms.repo.FindCurrent(userID)
+}
+
+//History returns a page of the matches userID has played, most recent first.
+//cursor is the NextCursor of the previous page, or empty for the first page
+func (ms *Match) History(userID int32, filter *models.MatchFilter, cursor string, limit int64) (*models.MatchHistoryPage, Cerr.CError) {
+	offset, cerr := decodeCursor(cursor)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if limit <= 0 {
+		limit = DefaultMatchHistoryLimit
+	} else if limit > MaxMatchHistoryLimit {
+		limit = MaxMatchHistoryLimit
+	}
+
+	//fetch one extra match to know whether there is a next page
+	filter.Offset = offset
+	filter.Limit = limit + 1
+	matches, cerr := ms.repo.ListByUser(userID, filter)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	page := &models.MatchHistoryPage{Entries: make([]models.MatchHistoryEntry, 0, len(matches))}
+	if int64(len(matches)) > limit {
+		matches = matches[:limit]
+		page.NextCursor = encodeCursor(offset + limit)
+	}
+	for i := range matches {
+		page.Entries = append(page.Entries, historyEntry(&matches[i], userID))
+	}
+
+	return page, nil
+}
+
+//Report records the result of the current round a player claims for themselves. The round is decided once both
+//players' reports agree, and the match finishes once enough rounds are decided
+func (ms *Match) Report(id string, userID int32, result string) (*models.Match, Cerr.CError) {
+	score, ok := resultScores[result]
+	if !ok {
+		return nil, Cerr.NewNotFound("result")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	match, cerr := ms.playerMatch(id, userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if match.State != models.MatchActive {
+		return nil, Cerr.NewConflict("Match is not active")
+	}
+
+	match.Reports[userID] = score
+	oppScore, ok := match.Reports[match.Opponent(userID)]
+	if !ok {
+		return match, ms.repo.Set(match)
+	}
+	if score+oppScore != glicko.Win {
+		if cerr = ms.repo.Set(match); cerr != nil {
+			return nil, cerr
+		}
+		return nil, Cerr.NewConflict("Reported results don't match")
+	}
+
+	match.RoundScores = append(match.RoundScores, match.Reports[match.Players[0]])
+	match.Reports = make(map[int32]float64)
+	if !match.Decided() {
+		if cerr = ms.repo.Set(match); cerr != nil {
+			return nil, cerr
+		}
+		for _, userID := range match.Players {
+			_ = ms.notifier.Send(userID, MsgRoundEnded, match)
+		}
+		return match, nil
+	}
+
+	winnerID, outcome := leader(match)
+	if cerr = ms.finish(match, models.MatchFinished, outcome, winnerID, true); cerr != nil {
+		return nil, cerr
+	}
+
+	return match, nil
+}
+
+//AllowsMessage reports whether the rules of the match userID plays let them send chat messages of kind
+func (ms *Match) AllowsMessage(userID int32, kind string) bool {
+	match, cerr := ms.repo.FindCurrent(userID)
+	if cerr != nil {
+		//users are only paired for matches, let the hub decide what to do with unpaired users
+		return true
+	}
+
+	return match.Rules.AllowsMessage(kind)
+}
+
+//Concede ends the match in progress with the opponent of userID as the winner
+func (ms *Match) Concede(id string, userID int32) (*models.Match, Cerr.CError) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	match, cerr := ms.playerMatch(id, userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !match.InProgress() {
+		return nil, Cerr.NewConflict("Match is already over")
+	}
+
+	winner := match.Opponent(userID)
+	if cerr = ms.finish(match, models.MatchFinished, models.OutcomeConceded, &winner, true); cerr != nil {
+		return nil, cerr
+	}
+
+	return match, nil
+}
+
+//Connected is called when a user connects to the realtime channel. It cancels a pending abandonment
+//and starts the match once every player is connected
+func (ms *Match) Connected(userID int32) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if timer, ok := ms.timers[userID]; ok {
+		timer.Stop()
+		delete(ms.timers, userID)
+	}
+
+	match, cerr := ms.repo.FindCurrent(userID)
+	if cerr != nil {
+		return
+	}
+
+	if match.State == models.MatchActive {
+		_ = ms.notifier.Send(match.Opponent(userID), MsgPeerReconnected, match)
+		return
+	}
+	_ = ms.startIfReady(match)
+}
+
+//Disconnected is called when the last connection of a user closes. If the user doesn't come back within
+//the grace period, their match is abandoned
+func (ms *Match) Disconnected(userID int32) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	match, cerr := ms.repo.FindCurrent(userID)
+	if cerr != nil {
+		return
+	}
+
+	_ = ms.notifier.Send(match.Opponent(userID), MsgPeerDisconnected, match)
+	ms.startTimer(match.Id, userID)
+}
+
+//playerMatch returns the match if userID plays in it. Must be called with the lock held
+func (ms *Match) playerMatch(id string, userID int32) (*models.Match, Cerr.CError) {
+	match, cerr := ms.repo.Get(id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !match.HasPlayer(userID) {
+		return nil, Cerr.NewForbidden("match")
+	}
+
+	return match, nil
+}
+
+//startIfReady must be called with the lock held
+func (ms *Match) startIfReady(match *models.Match) Cerr.CError {
+	if match.State != models.MatchPending {
+		return nil
+	}
+	for _, userID := range match.Players {
+		if !ms.notifier.Connected(userID) {
+			return nil
+		}
+	}
+
+	return ms.start(match)
+}
+
+//start must be called with the lock held
+func (ms *Match) start(match *models.Match) Cerr.CError {
+	now := ms.clock.Now()
+	match.State = models.MatchActive
+	match.StartedAt = &now
+	if limit := match.Rules.Duration(); limit > 0 {
+		endsAt := now.Add(limit)
+		match.EndsAt = &endsAt
+	}
+	if cerr := ms.repo.Set(match); cerr != nil {
+		return cerr
+	}
+	if match.EndsAt != nil {
+		matchID := match.Id
+		ms.deadlines[matchID] = ms.clock.AfterFunc(match.Rules.Duration(), func() {
+			ms.expire(matchID)
+		})
+	}
+
+	for _, userID := range match.Players {
+		_ = ms.notifier.Send(userID, MsgMatchStarted, match)
+	}
+
+	return nil
+}
+
+//finish ends the match, updating ratings if rated is set and the match is ranked. Must be called with the lock held
+func (ms *Match) finish(match *models.Match, state, outcome string, winnerID *int32, rated bool) Cerr.CError {
+	now := ms.clock.Now()
+	match.State = state
+	match.Outcome = outcome
+	match.WinnerID = winnerID
+	match.EndedAt = &now
+
+	if rated && match.Rules.Ranked {
+		scoreA := glicko.Draw
+		if winnerID != nil && *winnerID == match.Players[0] {
+			scoreA = glicko.Win
+		} else if winnerID != nil {
+			scoreA = glicko.Loss
+		}
+		changeA, changeB, cerr := ms.ratingSvc.Record(match.CategoryID, match.Players[0], match.Players[1], scoreA)
+		if cerr != nil {
+			return cerr
+		}
+		match.RatingChanges = map[int32]float64{
+			match.Players[0]: changeA.Delta(),
+			match.Players[1]: changeB.Delta(),
+		}
+	}
+
+	if cerr := ms.repo.Set(match); cerr != nil {
+		return cerr
+	}
+
+	if timer, ok := ms.deadlines[match.Id]; ok {
+		timer.Stop()
+		delete(ms.deadlines, match.Id)
+	}
+	ms.notifier.Unpair(match.Players[0])
+	for _, userID := range match.Players {
+		if timer, ok := ms.timers[userID]; ok {
+			timer.Stop()
+			delete(ms.timers, userID)
+		}
+		_ = ms.notifier.Send(userID, MsgMatchEnded, match)
+	}
+
+	return nil
+}
+
+//startTimer must be called with the lock held
+func (ms *Match) startTimer(matchID string, userID int32) {
+	if timer, ok := ms.timers[userID]; ok {
+		timer.Stop()
+	}
+	ms.timers[userID] = ms.clock.AfterFunc(ms.grace, func() {
+		ms.abandon(matchID, userID)
+	})
+}
+
+//abandon ends the match of a player that didn't come back within the grace period.
+//A match that never started is cancelled unrated. Otherwise the leaver loses it if the opponent is still around
+func (ms *Match) abandon(matchID string, userID int32) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.timers, userID)
+	if ms.notifier.Connected(userID) {
+		return
+	}
+
+	match, cerr := ms.repo.Get(matchID)
+	if cerr != nil || !match.InProgress() {
+		return
+	}
+	if match.State == models.MatchPending {
+		_ = ms.finish(match, models.MatchCancelled, models.OutcomeNoShow, nil, false)
+		return
+	}
+
+	opponent := match.Opponent(userID)
+	if ms.notifier.Connected(opponent) {
+		_ = ms.finish(match, models.MatchAbandoned, models.OutcomeAbandoned, &opponent, true)
+		return
+	}
+	_ = ms.finish(match, models.MatchAbandoned, models.OutcomeAbandoned, nil, false)
+}
+
+//expire ends an active match that ran out of time. The player ahead on rounds wins,
+//and the match is only rated if at least one round was decided
+func (ms *Match) expire(matchID string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.deadlines, matchID)
+	match, cerr := ms.repo.Get(matchID)
+	if cerr != nil || match.State != models.MatchActive {
+		return
+	}
+
+	winnerID, _ := leader(match)
+	_ = ms.finish(match, models.MatchFinished, models.OutcomeTimeout, winnerID, len(match.RoundScores) > 0)
+}
+
+//leader returns the player ahead on rounds and the outcome of the match if it ended now
+func leader(match *models.Match) (*int32, string) {
+	a, b := match.Score()
+	if a == b {
+		return nil, models.OutcomeDraw
+	}
+
+	winner := match.Players[0]
+	if b > a {
+		winner = match.Players[1]
+	}
+	return &winner, models.OutcomeWin
+}
+
+//historyEntry describes match from the point of view of userID
+func historyEntry(match *models.Match, userID int32) models.MatchHistoryEntry {
+	entry := models.MatchHistoryEntry{
+		MatchID:     match.Id,
+		CategoryID:  match.CategoryID,
+		OpponentID:  match.Opponent(userID),
+		State:       match.State,
+		Outcome:     match.Outcome,
+		RatingDelta: match.RatingChanges[userID],
+		CreatedAt:   match.CreatedAt,
+		StartedAt:   match.StartedAt,
+		EndedAt:     match.EndedAt,
+	}
+
+	if match.WinnerID != nil && *match.WinnerID == userID {
+		entry.Result = ResultWin
+	} else if match.WinnerID != nil {
+		entry.Result = ResultLoss
+	} else if match.Outcome == models.OutcomeDraw || match.Outcome == models.OutcomeTimeout {
+		entry.Result = ResultDraw
+	}
+
+	if match.StartedAt != nil && match.EndedAt != nil {
+		entry.Duration = match.EndedAt.Sub(*match.StartedAt).Seconds()
+	}
+
+	return entry
+}
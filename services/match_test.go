@@ -0,0 +1,42 @@
+package services
+
+import (
+	"mmr/models"
+	"testing"
+	"time"
+)
+
+func TestNoShowCancelsPendingMatchUnrated(t *testing.T) {
+	f := newMatchmakingFixture(t)
+	f.join(t, 1, 1500)
+	f.join(t, 2, 1500)
+	match, cerr := f.matchSvc.Current(1)
+	if cerr != nil {
+		t.Fatalf("current match: %v", cerr)
+	}
+	if match.State != models.MatchPending {
+		t.Fatalf("match is %s, want pending", match.State)
+	}
+
+	//nobody ever connects
+	f.clock.Advance(30 * time.Second)
+	if match, cerr = f.matchSvc.Get(match.Id); cerr != nil {
+		t.Fatalf("get match: %v", cerr)
+	}
+	if match.State != models.MatchCancelled || match.Outcome != models.OutcomeNoShow || match.WinnerID != nil {
+		t.Errorf("got state %s, outcome %s, winner %v, want a cancelled no show", match.State, match.Outcome,
+			match.WinnerID)
+	}
+	if len(match.RatingChanges) != 0 {
+		t.Errorf("cancelled match changed ratings: %v", match.RatingChanges)
+	}
+	for _, userID := range match.Players {
+		rating, cerr := f.ratingRepo.Get(userID, f.categoryID)
+		if cerr != nil {
+			t.Fatalf("rating of %d: %v", userID, cerr)
+		}
+		if rating.Rating != 1500 {
+			t.Errorf("rating of %d is %v, want 1500", userID, rating.Rating)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/shared"
+	"os"
+	"sync"
+	"time"
+)
+
+//MsgMatchFound is the realtime message type sent to both users when they are paired
+const MsgMatchFound = "match_found"
+
+type QueueRepository interface {
+	Get(userID int32) (*models.QueueEntry, Cerr.CError)
+	Set(entry *models.QueueEntry) Cerr.CError
+	Del(userID int32) Cerr.CError
+	List(categoryID int32) ([]models.QueueEntry, Cerr.CError)
+	ListAll() ([]models.QueueEntry, Cerr.CError)
+}
+
+//Notifier delivers realtime messages to connected users
+type Notifier interface {
+	Send(userID int32, msgType string, payload interface{}) Cerr.CError
+	Pair(a, b int32)
+	Unpair(userID int32)
+	Connected(userID int32) bool
+}
+
+type MatchFound struct {
+	MatchID    string `json:"match_id"`
+	CategoryID int32  `json:"category_id"`
+	OpponentID int32  `json:"opponent_id"`
+}
+
+//SearchWindow describes how far apart the ratings of two paired users may be.
+//The window starts at Initial and grows by Growth per second of waiting up to Ceiling.
+//Once a user has waited for MaxWait, they are paired with the closest rated user available
+type SearchWindow struct {
+	Initial float64
+	Growth  float64
+	Ceiling float64
+	MaxWait time.Duration
+}
+
+//Width returns the width of the window after waiting for wait
+func (sw SearchWindow) Width(wait time.Duration) float64 {
+	if sw.MaxWait > 0 && wait >= sw.MaxWait {
+		return math.Inf(1)
+	}
+
+	return math.Min(sw.Initial+sw.Growth*wait.Seconds(), sw.Ceiling)
+}
+
+type MatchmakingConfig struct {
+	Window SearchWindow
+	//per category overrides of Window
+	CategoryWindows map[int32]SearchWindow
+	//how long a user waits alone in the queue of a subcategory before moving up to its parent category, 0 never
+	Fallback time.Duration
+}
+
+var DefaultMatchmakingConfig = MatchmakingConfig{
+	Window: SearchWindow{
+		Initial: 50,
+		Growth:  5,
+		Ceiling: 400,
+		MaxWait: 2 * time.Minute,
+	},
+	CategoryWindows: make(map[int32]SearchWindow),
+	Fallback:        30 * time.Second,
+}
+
+type Matchmaking struct {
+	queueRepo QueueRepository
+	ctgRepo   CategoryRepository
+	ratingSvc *Rating
+	matchSvc  *Match
+	notifier  Notifier
+	clock     shared.Clock
+	cfg       MatchmakingConfig
+	//every policy must let a user through for them to queue for a ranked category
+	rankedPolicies []Policy
+	//serializes queue changes so that a user can't be matched twice
+	mu sync.Mutex
+}
+
+func NewMatchmaking(queueRepo QueueRepository, ctgRepo CategoryRepository, ratingSvc *Rating, matchSvc *Match,
+	notifier Notifier, clock shared.Clock, cfg MatchmakingConfig) *Matchmaking {
+	return &Matchmaking{
+		queueRepo: queueRepo,
+		ctgRepo:   ctgRepo,
+		ratingSvc: ratingSvc,
+		matchSvc:  matchSvc,
+		notifier:  notifier,
+		clock:     clock,
+		cfg:       cfg,
+		mu:        sync.Mutex{},
+	}
+}
+
+//RequireRanked adds policies a user must satisfy to queue for a ranked category, unranked ones are open to everyone
+func (mm *Matchmaking) RequireRanked(policies ...Policy) {
+	mm.rankedPolicies = append(mm.rankedPolicies, policies...)
+}
+
+//Join puts the user in the queue of the category, replacing any previous entry, and tries to find an opponent right away
+func (mm *Matchmaking) Join(userID, categoryID int32) (*models.QueueEntry, Cerr.CError) {
+	ancestors, cerr := categoryAncestors(mm.ctgRepo, categoryID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	for _, ctg := range ancestors {
+		if ctg.Archived {
+			return nil, Cerr.NewConflict("Category is archived")
+		}
+	}
+	if cerr = mm.allowed(userID, &ancestors[0]); cerr != nil {
+		return nil, cerr
+	}
+
+	if _, cerr := mm.matchSvc.Current(userID); cerr == nil {
+		return nil, Cerr.NewConflict("Already playing a match")
+	} else if _, ok := cerr.(Cerr.NotFound); !ok {
+		return nil, cerr
+	}
+
+	rating, cerr := mm.ratingSvc.Get(userID, categoryID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !ancestors[0].Rules.AllowsRating(rating.Rating) {
+		return nil, Cerr.NewForbidden("category at your rating")
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	now := mm.clock.Now()
+	entry := &models.QueueEntry{
+		UserID:              userID,
+		CategoryID:          categoryID,
+		RequestedCategoryID: categoryID,
+		Rating:              rating.Rating,
+		JoinedAt:            now,
+		MovedAt:             now,
+	}
+	if cerr = mm.queueRepo.Set(entry); cerr != nil {
+		return nil, cerr
+	}
+
+	waiting, cerr := mm.queueRepo.List(categoryID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if cerr = mm.matchCategory(waiting); cerr != nil {
+		return nil, cerr
+	}
+
+	return entry, nil
+}
+
+//JoinTag puts the user in the queue of the category labeled tag with the most users waiting, see Join
+func (mm *Matchmaking) JoinTag(userID int32, tag string) (*models.QueueEntry, Cerr.CError) {
+	categories, cerr := mm.ctgRepo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+	entries, cerr := mm.queueRepo.ListAll()
+	if cerr != nil {
+		return nil, cerr
+	}
+	waiting := make(map[int32]int)
+	for _, entry := range entries {
+		waiting[entry.CategoryID]++
+	}
+
+	tag = normalizeTag(tag)
+	var best *models.Category
+	for _, ctg := range activeCategories(categories) {
+		if !ctg.HasTag(tag) {
+			continue
+		}
+		//categories are listed by position, so ties go to the first listed
+		if best == nil || waiting[ctg.Id] > waiting[best.Id] {
+			ctg := ctg
+			best = &ctg
+		}
+	}
+	if best == nil {
+		return nil, Cerr.NewNotFound("tag")
+	}
+
+	return mm.Join(userID, best.Id)
+}
+
+//Stats returns how many users are queued for and how many matches are in progress in each category that has any
+func (mm *Matchmaking) Stats() (map[int32]models.CategoryStats, Cerr.CError) {
+	entries, cerr := mm.queueRepo.ListAll()
+	if cerr != nil {
+		return nil, cerr
+	}
+	inProgress, cerr := mm.matchSvc.CountInProgress()
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	stats := make(map[int32]models.CategoryStats)
+	for _, entry := range entries {
+		s := stats[entry.CategoryID]
+		s.Queued++
+		stats[entry.CategoryID] = s
+	}
+	for categoryID, count := range inProgress {
+		s := stats[categoryID]
+		s.ActiveMatches = count
+		stats[categoryID] = s
+	}
+
+	return stats, nil
+}
+
+func (mm *Matchmaking) Leave(userID int32) Cerr.CError {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return mm.queueRepo.Del(userID)
+}
+
+func (mm *Matchmaking) Status(userID int32) (*models.QueueEntry, Cerr.CError) {
+	return mm.queueRepo.Get(userID)
+}
+
+//Tick runs a matching pass over every category, pairing users whose search windows have grown enough.
+//Users queued for categories archived since they joined are taken out of the queue first
+func (mm *Matchmaking) Tick() Cerr.CError {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	entries, cerr := mm.queueRepo.ListAll()
+	if cerr != nil {
+		return cerr
+	}
+	if entries, cerr = mm.dropArchived(entries); cerr != nil {
+		return cerr
+	}
+
+	//group by category while keeping the longest waiting first order
+	categories := make([]int32, 0)
+	byCategory := make(map[int32][]models.QueueEntry)
+	for _, entry := range entries {
+		if _, ok := byCategory[entry.CategoryID]; !ok {
+			categories = append(categories, entry.CategoryID)
+		}
+		byCategory[entry.CategoryID] = append(byCategory[entry.CategoryID], entry)
+	}
+
+	for _, categoryID := range categories {
+		if cerr = mm.matchCategory(byCategory[categoryID]); cerr != nil {
+			return cerr
+		}
+	}
+
+	return mm.fallBack()
+}
+
+//Run calls Tick every interval until stop is closed
+func (mm *Matchmaking) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = mm.Tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//matchCategory pairs the waiting entries of a single category, longest waiting first.
+//Each entry is paired with the closest rated entry that is within both search windows. Must be called with the lock held
+func (mm *Matchmaking) matchCategory(waiting []models.QueueEntry) Cerr.CError {
+	if len(waiting) < 2 {
+		return nil
+	}
+	category, cerr := mm.ctgRepo.Get(waiting[0].CategoryID)
+	if cerr != nil {
+		return cerr
+	}
+
+	now := mm.clock.Now()
+	matched := make(map[int32]bool)
+
+	for i, entry := range waiting {
+		if matched[entry.UserID] {
+			continue
+		}
+		window := mm.window(entry.CategoryID).Width(now.Sub(entry.JoinedAt))
+
+		best := -1
+		bestDiff := math.Inf(1)
+		for j, opponent := range waiting {
+			if j == i || matched[opponent.UserID] {
+				continue
+			}
+
+			diff := math.Abs(entry.Rating - opponent.Rating)
+			oppWindow := mm.window(opponent.CategoryID).Width(now.Sub(opponent.JoinedAt))
+			//a user past the max wait accepts anyone, and is accepted by anyone
+			if diff > math.Min(window, oppWindow) && !math.IsInf(window, 1) && !math.IsInf(oppWindow, 1) {
+				continue
+			}
+			if diff < bestDiff {
+				best, bestDiff = j, diff
+			}
+		}
+		if best == -1 {
+			continue
+		}
+
+		//users leave the queue only once their match exists, so that a failure leaves both waiting for the next pass
+		opponent := waiting[best]
+		match, cerr := mm.matchSvc.Create(category, entry.UserID, opponent.UserID)
+		if cerr != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't create match of users %d and %d: %v\n", entry.UserID, opponent.UserID, cerr)
+			continue
+		}
+		matched[entry.UserID] = true
+		matched[opponent.UserID] = true
+		if cerr := mm.queueRepo.Del(entry.UserID); cerr != nil {
+			return cerr
+		}
+		if cerr := mm.queueRepo.Del(opponent.UserID); cerr != nil {
+			return cerr
+		}
+
+		mm.notify(match)
+		if cerr = mm.matchSvc.StartIfReady(match.Id); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+//fallBack moves users that waited alone in the queue of a subcategory for cfg.Fallback up to the parent category,
+//where they are rated by their parent category rating, if its rules and policies allow them.
+//Must be called with the lock held
+func (mm *Matchmaking) fallBack() Cerr.CError {
+	if mm.cfg.Fallback <= 0 {
+		return nil
+	}
+
+	entries, cerr := mm.queueRepo.ListAll()
+	if cerr != nil {
+		return cerr
+	}
+	waiting := make(map[int32]int)
+	for _, entry := range entries {
+		waiting[entry.CategoryID]++
+	}
+
+	now := mm.clock.Now()
+	for _, entry := range entries {
+		if waiting[entry.CategoryID] > 1 || now.Sub(entry.MovedAt) < mm.cfg.Fallback {
+			continue
+		}
+
+		ctg, cerr := mm.ctgRepo.Get(entry.CategoryID)
+		if cerr != nil {
+			return cerr
+		}
+		if ctg.ParentID == nil {
+			continue
+		}
+		parent, cerr := mm.ctgRepo.Get(*ctg.ParentID)
+		if cerr != nil {
+			return cerr
+		}
+		if parent.Archived {
+			continue
+		}
+
+		rating, cerr := mm.ratingSvc.Get(entry.UserID, parent.Id)
+		if cerr != nil {
+			return cerr
+		}
+		if !parent.Rules.AllowsRating(rating.Rating) || mm.allowed(entry.UserID, parent) != nil {
+			continue
+		}
+		entry.CategoryID = parent.Id
+		entry.Rating = rating.Rating
+		entry.MovedAt = now
+		if cerr = mm.queueRepo.Set(&entry); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+//dropArchived takes the entries of archived categories and their subcategories out of the queue and returns the rest.
+//Must be called with the lock held
+func (mm *Matchmaking) dropArchived(entries []models.QueueEntry) ([]models.QueueEntry, Cerr.CError) {
+	if len(entries) == 0 {
+		return entries, nil
+	}
+	categories, cerr := mm.ctgRepo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+	active := make(map[int32]bool, len(categories))
+	for _, ctg := range activeCategories(categories) {
+		active[ctg.Id] = true
+	}
+
+	open := make([]models.QueueEntry, 0, len(entries))
+	for _, entry := range entries {
+		if active[entry.CategoryID] {
+			open = append(open, entry)
+			continue
+		}
+		if cerr = mm.queueRepo.Del(entry.UserID); cerr != nil {
+			return nil, cerr
+		}
+	}
+
+	return open, nil
+}
+
+//allowed returns the error of the first policy that keeps the user from queueing for the category
+func (mm *Matchmaking) allowed(userID int32, category *models.Category) Cerr.CError {
+	if !category.Rules.Ranked {
+		return nil
+	}
+	for _, policy := range mm.rankedPolicies {
+		if cerr := policy(userID); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+func (mm *Matchmaking) window(categoryID int32) SearchWindow {
+	if sw, ok := mm.cfg.CategoryWindows[categoryID]; ok {
+		return sw
+	}
+
+	return mm.cfg.Window
+}
+
+func (mm *Matchmaking) notify(match *models.Match) {
+	for _, userID := range match.Players {
+		_ = mm.notifier.Send(userID, MsgMatchFound, MatchFound{
+			MatchID:    match.Id,
+			CategoryID: match.CategoryID,
+			OpponentID: match.Opponent(userID),
+		})
+	}
+}
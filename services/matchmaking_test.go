@@ -0,0 +1,276 @@
+package services
+
+import (
+	"math"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/repositories/memRepos"
+	"mmr/shared"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeClock only moves when told to, firing the timers that come due synchronously
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     sync.Mutex
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) shared.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+//Advance moves the clock forward by d and runs the timers due by then
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	due := make([]*fakeTimer, 0)
+	pending := make([]*fakeTimer, 0, len(c.timers))
+	for _, timer := range c.timers {
+		if timer.stopped {
+			continue
+		}
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.stopped = true
+		due = append(due, timer)
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+//fakeNotifier drops messages, no user is ever connected
+type fakeNotifier struct{}
+
+func (fakeNotifier) Send(userID int32, msgType string, payload interface{}) Cerr.CError {
+	return nil
+}
+
+func (fakeNotifier) Pair(a, b int32) {}
+
+func (fakeNotifier) Unpair(userID int32) {}
+
+func (fakeNotifier) Connected(userID int32) bool {
+	return false
+}
+
+var testWindow = SearchWindow{
+	Initial: 50,
+	Growth:  5,
+	Ceiling: 400,
+	MaxWait: 2 * time.Minute,
+}
+
+type matchmakingFixture struct {
+	mm         *Matchmaking
+	matchSvc   *Match
+	ratingRepo *memRepos.Rating
+	queueRepo  *memRepos.Queue
+	ctgRepo    *memRepos.Category
+	clock      *fakeClock
+	categoryID int32
+}
+
+func newMatchmakingFixture(t *testing.T) *matchmakingFixture {
+	ctgRepo := memRepos.NewCategory(make(map[int32]memRepos.StoredCategory), 1)
+	categoryID, cerr := ctgRepo.Create(&models.Category{Name: "chess", Rules: models.DefaultMatchRules})
+	if cerr != nil {
+		t.Fatalf("create category: %v", cerr)
+	}
+
+	f := &matchmakingFixture{
+		ratingRepo: memRepos.NewRating(make(map[int32]map[int32]models.Rating)),
+		queueRepo:  memRepos.NewQueue(make(map[int32]models.QueueEntry)),
+		ctgRepo:    ctgRepo,
+		clock:      newFakeClock(),
+		categoryID: categoryID,
+	}
+	ratingSvc := NewRating(f.ratingRepo, memRepos.NewLeaderboard(make(map[int32]map[int32]float64)))
+	f.matchSvc = NewMatch(memRepos.NewMatch(make(map[string]models.Match)), ratingSvc, fakeNotifier{}, f.clock, 30*time.Second)
+	f.mm = NewMatchmaking(f.queueRepo, ctgRepo, ratingSvc, f.matchSvc, fakeNotifier{}, f.clock, MatchmakingConfig{
+		Window:          testWindow,
+		CategoryWindows: make(map[int32]SearchWindow),
+	})
+
+	return f
+}
+
+//join queues userID rated rating
+func (f *matchmakingFixture) join(t *testing.T, userID int32, rating float64) {
+	t.Helper()
+	if cerr := f.ratingRepo.Set(&models.Rating{UserID: userID, CategoryID: f.categoryID, Rating: rating}); cerr != nil {
+		t.Fatalf("set rating: %v", cerr)
+	}
+	if _, cerr := f.mm.Join(userID, f.categoryID); cerr != nil {
+		t.Fatalf("join %d: %v", userID, cerr)
+	}
+}
+
+func (f *matchmakingFixture) tick(t *testing.T) {
+	t.Helper()
+	if cerr := f.mm.Tick(); cerr != nil {
+		t.Fatalf("tick: %v", cerr)
+	}
+}
+
+//opponent returns who userID was matched with, or -1 if they are still waiting
+func (f *matchmakingFixture) opponent(t *testing.T, userID int32) int32 {
+	t.Helper()
+	match, cerr := f.matchSvc.Current(userID)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		if _, cerr := f.queueRepo.Get(userID); cerr != nil {
+			t.Fatalf("user %d is neither matched nor queued: %v", userID, cerr)
+		}
+		return -1
+	} else if cerr != nil {
+		t.Fatalf("current match of %d: %v", userID, cerr)
+	}
+
+	return match.Opponent(userID)
+}
+
+func TestSearchWindowWidth(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want float64
+	}{
+		{0, 50},
+		{10 * time.Second, 100},
+		{time.Minute, 350},
+		{119 * time.Second, 400},
+		{2 * time.Minute, math.Inf(1)},
+		{time.Hour, math.Inf(1)},
+	}
+	for _, tt := range tests {
+		if got := testWindow.Width(tt.wait); got != tt.want {
+			t.Errorf("Width(%v) = %v, want %v", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestTickWidensWindow(t *testing.T) {
+	f := newMatchmakingFixture(t)
+	f.join(t, 1, 1500)
+	f.join(t, 2, 1600)
+	if got := f.opponent(t, 1); got != -1 {
+		t.Fatalf("matched with %d right away, want waiting", got)
+	}
+
+	//75 wide after 5s
+	f.clock.Advance(5 * time.Second)
+	f.tick(t)
+	if got := f.opponent(t, 1); got != -1 {
+		t.Fatalf("matched with %d after 5s, want waiting", got)
+	}
+
+	//100 wide after 10s
+	f.clock.Advance(5 * time.Second)
+	f.tick(t)
+	if got := f.opponent(t, 1); got != 2 {
+		t.Fatalf("matched with %d after 10s, want 2", got)
+	}
+}
+
+func TestTickMatchesAnyoneAfterMaxWait(t *testing.T) {
+	f := newMatchmakingFixture(t)
+	f.join(t, 1, 1500)
+	f.join(t, 2, 2500)
+
+	f.clock.Advance(testWindow.MaxWait - time.Second)
+	f.tick(t)
+	if got := f.opponent(t, 1); got != -1 {
+		t.Fatalf("matched with %d at the ceiling, want waiting", got)
+	}
+
+	f.clock.Advance(time.Second)
+	f.tick(t)
+	if got := f.opponent(t, 1); got != 2 {
+		t.Fatalf("matched with %d after the max wait, want 2", got)
+	}
+}
+
+func TestTickPicksNearestRating(t *testing.T) {
+	f := newMatchmakingFixture(t)
+	f.join(t, 1, 1500)
+	f.clock.Advance(time.Second)
+	f.join(t, 2, 1700)
+	f.clock.Advance(time.Second)
+	f.join(t, 3, 1640)
+
+	//every window covers every other user
+	f.clock.Advance(time.Minute)
+	f.tick(t)
+	if got := f.opponent(t, 1); got != 3 {
+		t.Fatalf("1 matched with %d, want the nearest rated 3", got)
+	}
+	if got := f.opponent(t, 2); got != -1 {
+		t.Fatalf("2 matched with %d, want waiting", got)
+	}
+}
+
+func TestTickDropsArchivedCategories(t *testing.T) {
+	f := newMatchmakingFixture(t)
+	f.join(t, 1, 1500)
+	f.join(t, 2, 2500)
+
+	category, cerr := f.ctgRepo.Get(f.categoryID)
+	if cerr != nil {
+		t.Fatalf("get category: %v", cerr)
+	}
+	category.Archived = true
+	if cerr = f.ctgRepo.Update(category); cerr != nil {
+		t.Fatalf("archive category: %v", cerr)
+	}
+
+	//past the max wait the two would be matched, were the category still open
+	f.clock.Advance(testWindow.MaxWait)
+	f.tick(t)
+	for _, userID := range []int32{1, 2} {
+		if _, cerr := f.queueRepo.Get(userID); cerr == nil {
+			t.Errorf("%d is still queued for an archived category", userID)
+		}
+		if _, cerr := f.matchSvc.Current(userID); cerr == nil {
+			t.Errorf("%d was matched in an archived category", userID)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/oauth"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	PurposeOAuthState = "oauth_state"
+	OAuthStateTTL     = 10 * time.Minute
+)
+
+//IdentityProvider logs users in through the OAuth 2.0 authorization code flow with PKCE
+type IdentityProvider interface {
+	Name() string
+	//AuthURL returns where to send the user to log in at the provider
+	AuthURL(state, nonce, challenge, redirectURI string) string
+	//Exchange trades the authorization code for the identity of the user
+	Exchange(code, verifier, redirectURI, nonce string) (*models.Identity, error)
+}
+
+//oauthState is kept with the state token until the provider redirects back
+type oauthState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	//hash of the secret kept by the browser that started the login
+	Binding string `json:"binding"`
+}
+
+type OAuth struct {
+	auth         *Auth
+	identityRepo IdentityRepository
+	ottRepo      OneTimeTokenRepository
+	providers    map[string]IdentityProvider
+	//providers redirect back to {redirectBase}/{provider}/callback
+	redirectBase string
+}
+
+func NewOAuth(auth *Auth, identityRepo IdentityRepository, ottRepo OneTimeTokenRepository, redirectBase string,
+	providers ...IdentityProvider) *OAuth {
+	o := &OAuth{
+		auth:         auth,
+		identityRepo: identityRepo,
+		ottRepo:      ottRepo,
+		providers:    make(map[string]IdentityProvider, len(providers)),
+		redirectBase: redirectBase,
+	}
+	for _, provider := range providers {
+		o.providers[provider.Name()] = provider
+	}
+
+	return o
+}
+
+//Providers lists the names of the configured providers
+func (o *OAuth) Providers() []string {
+	names := make([]string, 0, len(o.providers))
+	for name := range o.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//Start begins a login at the provider and returns the URL to send the user to, along with the secret the browser
+//must keep until the provider redirects back so no one else can complete the login in it
+func (o *OAuth) Start(name string) (string, string, Cerr.CError) {
+	provider, ok := o.providers[name]
+	if !ok {
+		return "", "", Cerr.NewNotFound("provider")
+	}
+
+	verifier, err := oauth.NewVerifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate PKCE verifier: %v\n", err)
+		return "", "", Cerr.NewInternal()
+	}
+	nonce, err := oauth.NewVerifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate nonce: %v\n", err)
+		return "", "", Cerr.NewInternal()
+	}
+	binding, err := oauth.NewVerifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate browser binding: %v\n", err)
+		return "", "", Cerr.NewInternal()
+	}
+	data, err := json.Marshal(oauthState{Provider: name, Verifier: verifier, Nonce: nonce, Binding: hashSecret(binding)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't marshal oauth state: %v\n", err)
+		return "", "", Cerr.NewInternal()
+	}
+
+	state, cerr := issueOneTimeToken(o.ottRepo, &models.OneTimeToken{
+		Purpose: PurposeOAuthState,
+		Data:    string(data),
+	}, OAuthStateTTL)
+	if cerr != nil {
+		return "", "", cerr
+	}
+
+	return provider.AuthURL(state, nonce, oauth.Challenge(verifier), o.redirectURI(name)), binding, nil
+}
+
+//Callback completes a login at the provider, logging the user into the account linked to their identity.
+//An identity is linked to the account with the same email the first time, if the provider verified the email,
+//otherwise an account is created for it. Linking to an account whose email wasn't verified yet takes it over, see claim.
+//binding is the secret Start returned to the browser that began the login
+func (o *OAuth) Callback(name, state, binding, code string, session *models.Session) (*models.LoginResult,
+	Cerr.CError) {
+	token, cerr := redeemOneTimeToken(o.ottRepo, PurposeOAuthState, state)
+	if cerr != nil {
+		return nil, cerr
+	}
+	var st oauthState
+	if err := json.Unmarshal([]byte(token.Data), &st); err != nil || st.Provider != name {
+		return nil, Cerr.NewUnauthorized("state")
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(binding)), []byte(st.Binding)) != 1 {
+		return nil, Cerr.NewUnauthorized("state")
+	}
+
+	provider, ok := o.providers[name]
+	if !ok {
+		return nil, Cerr.NewNotFound("provider")
+	}
+	identity, err := provider.Exchange(code, st.Verifier, o.redirectURI(name), st.Nonce)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't exchange authorization code with %s: %v\n", name, err)
+		return nil, Cerr.NewUnauthorized("authorization code")
+	}
+
+	usr, cerr := o.resolve(identity)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return o.auth.authenticated(usr, session)
+}
+
+//resolve finds or creates the user identity belongs to
+func (o *OAuth) resolve(identity *models.Identity) (*models.User, Cerr.CError) {
+	linked, cerr := o.identityRepo.Get(identity.Provider, identity.Subject)
+	if cerr == nil {
+		return o.auth.usrRepo.FindById(linked.UserID)
+	} else if _, ok := cerr.(Cerr.NotFound); !ok {
+		return nil, cerr
+	}
+
+	if identity.Email == "" {
+		return nil, Cerr.NewUnauthorized("identity")
+	}
+
+	usr, cerr := o.auth.usrRepo.FindByEmail(identity.Email)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		if usr, cerr = o.register(identity); cerr != nil {
+			return nil, cerr
+		}
+	} else if cerr != nil {
+		return nil, cerr
+	} else {
+		//whoever controls the identity could otherwise take over an account by claiming its email
+		if !identity.EmailVerified {
+			return nil, Cerr.NewConflict("An account with this email already exists")
+		}
+		if !usr.Verified {
+			if cerr = o.claim(usr); cerr != nil {
+				return nil, cerr
+			}
+		}
+	}
+
+	identity.UserID = usr.Id
+	if cerr = o.identityRepo.Create(identity); cerr != nil {
+		return nil, cerr
+	}
+
+	return usr, nil
+}
+
+//claim hands an account nobody proved to own over to the identity with its verified email. Whoever registered it
+//may have done so to hijack it later, so their password, second factor and sessions stop working
+func (o *OAuth) claim(usr *models.User) Cerr.CError {
+	pass, err := oauth.NewVerifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate password: %v\n", err)
+		return Cerr.NewInternal()
+	}
+	if err = usr.HashPass(pass); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't hash the password: %v\n", err)
+		return Cerr.NewInternal()
+	}
+	usr.Verified = true
+	usr.TOTPSecret = ""
+	usr.TOTPEnabled = false
+	usr.TOTPLastStep = 0
+	usr.RecoveryCodes = nil
+	if cerr := o.auth.usrRepo.Update(usr); cerr != nil {
+		return cerr
+	}
+
+	return o.auth.tokenRepo.DelUser(usr.Id)
+}
+
+//register creates the account of an identity. It gets a random password, which the user can reset to log in without
+//the provider
+func (o *OAuth) register(identity *models.Identity) (*models.User, Cerr.CError) {
+	pass, err := oauth.NewVerifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate password: %v\n", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	name := []rune(identity.Name)
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	usr := &models.User{
+		Name:     string(name),
+		Email:    identity.Email,
+		Verified: identity.EmailVerified,
+	}
+	if err = usr.HashPass(pass); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't hash the password: %v\n", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	userID, cerr := o.auth.usrRepo.Create(usr)
+	if cerr != nil {
+		return nil, cerr
+	}
+	usr.Id = userID
+	o.auth.registered(usr)
+
+	return usr, nil
+}
+
+func (o *OAuth) redirectURI(name string) string {
+	return o.redirectBase + "/" + name + "/callback"
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	Cerr "mmr/errors"
+	"mmr/keys"
+	"mmr/models"
+	"mmr/oauth"
+	"mmr/repositories/memRepos"
+	"mmr/shared"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stubProvider = "stub"
+	stubClientID = "mmr"
+)
+
+//stubIssuer is an OpenID Connect issuer whose users log in by the test calling authorize
+type stubIssuer struct {
+	srv    *httptest.Server
+	keyMgr *keys.Manager
+	//code -> login it was issued for
+	grants map[string]stubGrant
+	mu     sync.Mutex
+}
+
+type stubGrant struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	keyMgr, err := keys.NewManager(keys.RS256, time.Hour)
+	if err != nil {
+		t.Fatalf("new key manager: %v", err)
+	}
+	if err = keyMgr.Rotate(); err != nil {
+		t.Fatalf("rotate keys: %v", err)
+	}
+
+	s := &stubIssuer{keyMgr: keyMgr, grants: make(map[string]stubGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeStubJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 s.srv.URL,
+			"authorization_endpoint": s.srv.URL + "/authorize",
+			"token_endpoint":         s.srv.URL + "/token",
+			"jwks_uri":               s.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeStubJSON(w, http.StatusOK, s.keyMgr.JWKS())
+	})
+	mux.HandleFunc("/token", s.token)
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func writeStubJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+//token redeems a code for an id token, if the PKCE verifier matches the challenge of the login
+func (s *stubIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	grant, ok := s.grants[r.PostForm.Get("code")]
+	delete(s.grants, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	switch {
+	case !ok, r.PostForm.Get("client_id") != stubClientID, r.PostForm.Get("redirect_uri") != grant.redirectURI:
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	case oauth.Challenge(r.PostForm.Get("code_verifier")) != grant.challenge:
+		writeStubJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "code verifier doesn't match the challenge",
+		})
+	default:
+		idToken, err := s.keyMgr.Sign(grant.claims)
+		if err != nil {
+			writeStubJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+		writeStubJSON(w, http.StatusOK, map[string]string{"id_token": idToken})
+	}
+}
+
+//authorize logs the user with claims in at the auth URL and returns the state and code the issuer redirects back with.
+//Claims not given are filled in with what a well behaved issuer would send
+func (s *stubIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (string, string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth url: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("auth url has no S256 challenge: %s", authURL)
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss":   s.srv.URL,
+		"aud":   query.Get("client_id"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range claims {
+		idClaims[k] = v
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := fmt.Sprintf("code-%d", len(s.grants)+1)
+	s.grants[code] = stubGrant{
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		claims:      idClaims,
+	}
+
+	return query.Get("state"), code
+}
+
+type oauthFixture struct {
+	issuer    *stubIssuer
+	keyMgr    *keys.Manager
+	oauthSvc  *OAuth
+	authSvc   *Auth
+	usrRepo   *memRepos.User
+	tokenRepo *memRepos.Token
+}
+
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	issuer := newStubIssuer(t)
+	provider, err := oauth.NewOIDC(stubProvider, issuer.srv.URL, stubClientID, "", issuer.srv.Client())
+	if err != nil {
+		t.Fatalf("new oidc provider: %v", err)
+	}
+
+	keyMgr, err := keys.NewManager(keys.RS256, MaxTokenTTL)
+	if err != nil {
+		t.Fatalf("new key manager: %v", err)
+	}
+	if err = keyMgr.Rotate(); err != nil {
+		t.Fatalf("rotate keys: %v", err)
+	}
+	box, err := shared.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+
+	f := &oauthFixture{
+		issuer:    issuer,
+		keyMgr:    keyMgr,
+		usrRepo:   memRepos.NewUser(make(map[int32]models.User), 1),
+		tokenRepo: memRepos.NewToken(make(map[string]models.Token)),
+	}
+	ottRepo := memRepos.NewOneTimeToken(make(map[string]map[string]models.OneTimeToken))
+	throttleSvc := NewThrottle(memRepos.NewThrottle(), shared.SystemClock, DefaultThrottleConfig)
+	tfSvc := NewTwoFactor(f.usrRepo, ottRepo, box, throttleSvc, shared.SystemClock, "test")
+	f.authSvc = NewAuth(f.usrRepo, f.tokenRepo, keyMgr, tfSvc, throttleSvc)
+	f.oauthSvc = NewOAuth(f.authSvc, memRepos.NewIdentity(), ottRepo, "https://api.example.com/auth/oauth", provider)
+
+	return f
+}
+
+//login goes through a login at the stub issuer as the user with claims
+func (f *oauthFixture) login(t *testing.T, claims jwt.MapClaims) (*models.LoginResult, Cerr.CError) {
+	t.Helper()
+	authURL, binding, cerr := f.oauthSvc.Start(stubProvider)
+	if cerr != nil {
+		t.Fatalf("start: %v", cerr)
+	}
+	state, code := f.issuer.authorize(t, authURL, claims)
+
+	return f.oauthSvc.Callback(stubProvider, state, binding, code, &models.Session{})
+}
+
+//userOf returns the user an access token was issued to
+func (f *oauthFixture) userOf(t *testing.T, res *models.LoginResult) *models.User {
+	t.Helper()
+	if res == nil || res.AccessToken == "" {
+		t.Fatalf("got %+v, want an access token", res)
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(res.AccessToken, claims, f.keyMgr.Keyfunc); err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	uuid, _ := claims["uuid"].(string)
+	token, cerr := f.authSvc.GetToken(uuid)
+	if cerr != nil {
+		t.Fatalf("get token %s: %v", uuid, cerr)
+	}
+	usr, cerr := f.usrRepo.FindById(token.UserID)
+	if cerr != nil {
+		t.Fatalf("find user %d: %v", token.UserID, cerr)
+	}
+
+	return usr
+}
+
+func (f *oauthFixture) register(t *testing.T, email string, verified bool) *models.User {
+	t.Helper()
+	usr := &models.User{Email: email, Pass: "secret123"}
+	if _, _, cerr := f.authSvc.Register(usr, &models.Session{}); cerr != nil {
+		t.Fatalf("register %s: %v", email, cerr)
+	}
+	if verified {
+		usr.Verified = true
+		if cerr := f.usrRepo.Update(usr); cerr != nil {
+			t.Fatalf("verify %s: %v", email, cerr)
+		}
+	}
+
+	return usr
+}
+
+func TestOIDCDiscoveryChecksIssuer(t *testing.T) {
+	issuer := newStubIssuer(t)
+	if _, err := oauth.NewOIDC(stubProvider, issuer.srv.URL+"/", stubClientID, "", issuer.srv.Client()); err == nil {
+		t.Error("discovered an issuer other than the one configured")
+	}
+	if _, err := oauth.NewOIDC(stubProvider, issuer.srv.URL+"/other", stubClientID, "", issuer.srv.Client()); err == nil {
+		t.Error("discovered an issuer without a discovery document")
+	}
+}
+
+func TestOIDCExchangeSendsVerifier(t *testing.T) {
+	f := newOAuthFixture(t)
+	provider := f.oauthSvc.providers[stubProvider]
+	redirectURI := f.oauthSvc.redirectURI(stubProvider)
+	claims := jwt.MapClaims{"sub": "1", "nonce": "nonce"}
+
+	authURL := provider.AuthURL("state", "nonce", oauth.Challenge("verifier"), redirectURI)
+	_, code := f.issuer.authorize(t, authURL, claims)
+	if _, err := provider.Exchange(code, "other verifier", redirectURI, "nonce"); err == nil {
+		t.Error("exchanged a code with the wrong verifier")
+	}
+
+	_, code = f.issuer.authorize(t, authURL, claims)
+	identity, err := provider.Exchange(code, "verifier", redirectURI, "nonce")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if identity.Provider != stubProvider || identity.Subject != "1" {
+		t.Errorf("got identity %+v, want subject 1 at %s", identity, stubProvider)
+	}
+}
+
+func TestOAuthCallbackRejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"wrong nonce", jwt.MapClaims{"nonce": "replayed"}},
+		{"wrong audience", jwt.MapClaims{"aud": "someone else"}},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"no subject", jwt.MapClaims{"sub": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(t)
+			claims := jwt.MapClaims{"sub": "1", "email": "player@example.com", "email_verified": true}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+
+			_, cerr := f.login(t, claims)
+			if _, ok := cerr.(Cerr.Unauthorized); !ok {
+				t.Errorf("got %v, want unauthorized", cerr)
+			}
+		})
+	}
+}
+
+func TestOAuthCallbackChecksState(t *testing.T) {
+	f := newOAuthFixture(t)
+	claims := jwt.MapClaims{"sub": "1", "email": "player@example.com", "email_verified": true}
+
+	authURL, binding, cerr := f.oauthSvc.Start(stubProvider)
+	if cerr != nil {
+		t.Fatalf("start: %v", cerr)
+	}
+	state, code := f.issuer.authorize(t, authURL, claims)
+	//the login of someone else, completed in another browser
+	if _, cerr = f.oauthSvc.Callback(stubProvider, state, "other binding", code, &models.Session{}); cerr == nil {
+		t.Error("completed a login with the binding of another browser")
+	}
+	//the state is used up either way
+	if _, cerr = f.oauthSvc.Callback(stubProvider, state, binding, code, &models.Session{}); cerr == nil {
+		t.Error("completed a login with a redeemed state")
+	}
+}
+
+func TestOAuthCallbackCreatesAccount(t *testing.T) {
+	f := newOAuthFixture(t)
+	claims := jwt.MapClaims{"sub": "1", "email": "player@example.com", "email_verified": true, "name": "player"}
+
+	res, cerr := f.login(t, claims)
+	if cerr != nil {
+		t.Fatalf("first login: %v", cerr)
+	}
+	usr := f.userOf(t, res)
+	if usr.Email != "player@example.com" || !usr.Verified {
+		t.Errorf("created %+v, want a verified account of player@example.com", usr)
+	}
+
+	//the identity stays linked even once the email changes at the provider
+	claims["email"] = "renamed@example.com"
+	if res, cerr = f.login(t, claims); cerr != nil {
+		t.Fatalf("second login: %v", cerr)
+	}
+	if got := f.userOf(t, res); got.Id != usr.Id {
+		t.Errorf("second login got user %d, want %d", got.Id, usr.Id)
+	}
+}
+
+func TestOAuthCallbackLinksVerifiedEmail(t *testing.T) {
+	f := newOAuthFixture(t)
+	usr := f.register(t, "player@example.com", true)
+
+	_, cerr := f.login(t, jwt.MapClaims{"sub": "1", "email": "player@example.com", "email_verified": false})
+	if _, ok := cerr.(Cerr.Conflict); !ok {
+		t.Fatalf("unverified email got %v, want conflict", cerr)
+	}
+
+	res, cerr := f.login(t, jwt.MapClaims{"sub": "2", "email": "player@example.com", "email_verified": true})
+	if cerr != nil {
+		t.Fatalf("verified email: %v", cerr)
+	}
+	if got := f.userOf(t, res); got.Id != usr.Id {
+		t.Errorf("verified email got user %d, want %d", got.Id, usr.Id)
+	}
+	if _, cerr = f.authSvc.Login(&models.User{Email: "player@example.com", Pass: "secret123"},
+		&models.Session{}); cerr != nil {
+		t.Errorf("password of a verified account stopped working: %v", cerr)
+	}
+}
+
+func TestOAuthCallbackClaimsUnverifiedAccount(t *testing.T) {
+	f := newOAuthFixture(t)
+	squatter := f.register(t, "victim@example.com", false)
+	squatter.TOTPEnabled = true
+	squatter.TOTPSecret = "sealed"
+	if cerr := f.usrRepo.Update(squatter); cerr != nil {
+		t.Fatalf("enable 2fa: %v", cerr)
+	}
+
+	res, cerr := f.login(t, jwt.MapClaims{"sub": "1", "email": "victim@example.com", "email_verified": true})
+	if cerr != nil {
+		t.Fatalf("login: %v", cerr)
+	}
+	usr := f.userOf(t, res)
+	if usr.Id != squatter.Id {
+		t.Fatalf("got user %d, want %d", usr.Id, squatter.Id)
+	}
+	if !usr.Verified || usr.TOTPEnabled || usr.TOTPSecret != "" {
+		t.Errorf("claimed account is %+v, want verified without a second factor", usr)
+	}
+
+	if _, cerr = f.authSvc.Login(&models.User{Email: "victim@example.com", Pass: "secret123"},
+		&models.Session{}); cerr == nil {
+		t.Error("the password set before the account was claimed still works")
+	}
+	sessions, cerr := f.tokenRepo.ListSessions(usr.Id)
+	if cerr != nil {
+		t.Fatalf("list sessions: %v", cerr)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("got %d sessions, want just the one of the login", len(sessions))
+	}
+}
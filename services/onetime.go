@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"time"
+)
+
+type OneTimeTokenRepository interface {
+	Set(token *models.OneTimeToken, exp time.Duration) Cerr.CError
+	//Take removes the token of purpose with hash and returns it, Unauthorized if there is none
+	Take(purpose, hash string) (*models.OneTimeToken, Cerr.CError)
+	//DelUser removes every token of purpose issued to the user
+	DelUser(purpose string, userID int32) Cerr.CError
+}
+
+//Mailer delivers emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+//issueOneTimeToken stores token, filling its hash and expiry, and returns the secret to mail the user
+func issueOneTimeToken(repo OneTimeTokenRepository, token *models.OneTimeToken, ttl time.Duration) (string, Cerr.CError) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate token: %v\n", err)
+		return "", Cerr.NewInternal()
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+
+	token.Hash = hashSecret(secret)
+	token.ExpiresAt = time.Now().Add(ttl)
+	if cerr := repo.Set(token, ttl); cerr != nil {
+		return "", cerr
+	}
+
+	return secret, nil
+}
+
+//redeemOneTimeToken consumes the token of purpose the secret belongs to
+func redeemOneTimeToken(repo OneTimeTokenRepository, purpose, secret string) (*models.OneTimeToken, Cerr.CError) {
+	token, cerr := repo.Take(purpose, hashSecret(secret))
+	if cerr != nil {
+		return nil, cerr
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, Cerr.NewUnauthorized("token")
+	}
+
+	return token, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"time"
+)
+
+const (
+	PurposePasswordReset = "password_reset"
+	passwordResetTTL     = time.Hour
+)
+
+type Password struct {
+	usrRepo   UserRepository
+	ottRepo   OneTimeTokenRepository
+	tokenRepo TokenRepository
+	throttle  *Throttle
+	mailer    Mailer
+	//links in emails point to the frontend served at baseURL
+	baseURL string
+}
+
+func NewPassword(usrRepo UserRepository, ottRepo OneTimeTokenRepository, tokenRepo TokenRepository, throttle *Throttle,
+	mailer Mailer, baseURL string) *Password {
+	return &Password{
+		usrRepo:   usrRepo,
+		ottRepo:   ottRepo,
+		tokenRepo: tokenRepo,
+		throttle:  throttle,
+		mailer:    mailer,
+		baseURL:   baseURL,
+	}
+}
+
+//Forgot mails a password reset link to the user with email. Requests are rate limited per address, whether or not
+//it is registered, and per ip. Unknown addresses are ignored silently, so that the endpoint can't be used to find
+//out who is registered
+func (p *Password) Forgot(email, ip string) Cerr.CError {
+	if cerr := p.throttle.HitPasswordReset(email, ip); cerr != nil {
+		return cerr
+	}
+
+	usr, cerr := p.usrRepo.FindByEmail(email)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		return nil
+	} else if cerr != nil {
+		return cerr
+	}
+
+	secret, cerr := issueOneTimeToken(p.ottRepo, &models.OneTimeToken{
+		Purpose: PurposePasswordReset,
+		UserID:  usr.Id,
+	}, passwordResetTTL)
+	if cerr != nil {
+		return cerr
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your account.\n\n"+
+		"Follow %s/reset-password?token=%s within %d minutes to choose a new one.\n\n"+
+		"If it wasn't you, ignore this email.", p.baseURL, secret, int(passwordResetTTL.Minutes()))
+	if err := p.mailer.Send(usr.Email, "Reset your password", body); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't send password reset email: %v\n", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
+
+//Reset sets the password of the user the reset token was issued to and ends every session of the user.
+//Every other reset link mailed to the user stops working
+func (p *Password) Reset(secret, pass string) Cerr.CError {
+	token, cerr := redeemOneTimeToken(p.ottRepo, PurposePasswordReset, secret)
+	if cerr != nil {
+		return cerr
+	}
+
+	usr, cerr := p.usrRepo.FindById(token.UserID)
+	if cerr != nil {
+		return cerr
+	}
+	if err := usr.HashPass(pass); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't hash the password: %v\n", err)
+		return Cerr.NewInternal()
+	}
+	if cerr = p.usrRepo.Update(usr); cerr != nil {
+		return cerr
+	}
+	if cerr = p.ottRepo.DelUser(PurposePasswordReset, usr.Id); cerr != nil {
+		return cerr
+	}
+
+	return p.tokenRepo.DelUser(usr.Id)
+}
+
+//Change sets a new password for the user, who has to confirm the current one.
+//Every other session of the user ends, the one in family stays logged in. Wrong guesses are throttled per user
+func (p *Password) Change(userID int32, current, pass, family string) Cerr.CError {
+	usr, cerr := p.usrRepo.FindById(userID)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr = p.throttle.CheckPassword(usr, current); cerr != nil {
+		return cerr
+	}
+	if err := usr.HashPass(pass); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't hash the password: %v\n", err)
+		return Cerr.NewInternal()
+	}
+	if cerr = p.usrRepo.Update(usr); cerr != nil {
+		return cerr
+	}
+
+	sessions, cerr := p.tokenRepo.ListSessions(userID)
+	if cerr != nil {
+		return cerr
+	}
+	for _, session := range sessions {
+		if session.Id == family {
+			continue
+		}
+		if cerr = p.tokenRepo.DelFamily(session.Id); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
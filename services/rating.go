@@ -0,0 +1,172 @@
+package services
+
+import (
+	"math"
+	Cerr "mmr/errors"
+	"mmr/glicko"
+	"mmr/models"
+	"sync"
+)
+
+type RatingRepository interface {
+	Get(userID, categoryID int32) (*models.Rating, Cerr.CError)
+	Set(rating *models.Rating) Cerr.CError
+	ListByUser(userID int32) ([]models.Rating, Cerr.CError)
+	ListAll() ([]models.Rating, Cerr.CError)
+}
+
+//SoftReset pulls ratings toward Target by Factor (0 keeps ratings as they are, 1 resets everyone to Target)
+//and raises deviations to at least MinDeviation, so that ratings settle quickly in the new season
+type SoftReset struct {
+	Target       float64
+	Factor       float64
+	MinDeviation float64
+}
+
+//RatingChange is the rating of a player before and after a match was recorded
+type RatingChange struct {
+	Before *models.Rating
+	After  *models.Rating
+}
+
+//Delta returns how much the match moved the rating
+func (c *RatingChange) Delta() float64 {
+	return c.After.Rating - c.Before.Rating
+}
+
+type Rating struct {
+	repo   RatingRepository
+	lbRepo LeaderboardRepository
+	//serializes read-modify-write cycles of ratings
+	mu sync.Mutex
+}
+
+func NewRating(repo RatingRepository, lbRepo LeaderboardRepository) *Rating {
+	return &Rating{
+		repo:   repo,
+		lbRepo: lbRepo,
+		mu:     sync.Mutex{},
+	}
+}
+
+//Get returns the rating of the user in the category, or the default rating if the user hasn't played there yet
+func (rt *Rating) Get(userID, categoryID int32) (*models.Rating, Cerr.CError) {
+	rating, cerr := rt.repo.Get(userID, categoryID)
+	if _, ok := cerr.(Cerr.NotFound); ok {
+		return defaultRating(userID, categoryID), nil
+	} else if cerr != nil {
+		return nil, cerr
+	}
+
+	return rating, nil
+}
+
+func (rt *Rating) List(userID int32) ([]models.Rating, Cerr.CError) {
+	return rt.repo.ListByUser(userID)
+}
+
+//Record updates the ratings of both players of a finished match. scoreA is the score of playerA, see glicko.Win/Draw/Loss.
+//Returns how the ratings of playerA and playerB changed
+func (rt *Rating) Record(categoryID, playerA, playerB int32, scoreA float64) (*RatingChange, *RatingChange, Cerr.CError) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	a, cerr := rt.Get(playerA, categoryID)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	b, cerr := rt.Get(playerB, categoryID)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+
+	newA := fromGlicko(playerA, categoryID, glicko.Update(toGlicko(a), glicko.Result{Opponent: toGlicko(b), Score: scoreA}))
+	newB := fromGlicko(playerB, categoryID, glicko.Update(toGlicko(b), glicko.Result{Opponent: toGlicko(a), Score: 1 - scoreA}))
+	newA.ResetSeason, newB.ResetSeason = a.ResetSeason, b.ResetSeason
+
+	if cerr = rt.repo.Set(newA); cerr != nil {
+		return nil, nil, cerr
+	}
+	if cerr = rt.repo.Set(newB); cerr != nil {
+		_ = rt.repo.Set(a)
+		return nil, nil, cerr
+	}
+
+	//the leaderboard must reflect the stored ratings, so roll them back if it can't be updated
+	if cerr = rt.lbRepo.Set(categoryID, map[int32]float64{
+		playerA: newA.Rating,
+		playerB: newB.Rating,
+	}); cerr != nil {
+		_ = rt.repo.Set(a)
+		_ = rt.repo.Set(b)
+		return nil, nil, cerr
+	}
+
+	return &RatingChange{Before: a, After: newA}, &RatingChange{Before: b, After: newB}, nil
+}
+
+//SoftReset applies reset at the close of the season to every rating it wasn't applied to yet, marking them so that
+//a retry doesn't reset any twice. archive, unless nil, is called first with the ratings as the season closes.
+//No match can be recorded meanwhile, so the ratings archived are the ones reset and no result is lost
+func (rt *Rating) SoftReset(seasonID int32, reset SoftReset, archive func(final []models.Rating) Cerr.CError) Cerr.CError {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	ratings, cerr := rt.repo.ListAll()
+	if cerr != nil {
+		return cerr
+	}
+	if archive != nil {
+		if cerr = archive(ratings); cerr != nil {
+			return cerr
+		}
+	}
+
+	leaderboards := make(map[int32]map[int32]float64)
+	for _, stored := range ratings {
+		if stored.ResetSeason >= seasonID {
+			continue
+		}
+		stored.Rating = reset.Target + (stored.Rating-reset.Target)*(1-reset.Factor)
+		stored.Deviation = math.Max(stored.Deviation, reset.MinDeviation)
+		stored.ResetSeason = seasonID
+		if cerr = rt.repo.Set(&stored); cerr != nil {
+			return cerr
+		}
+
+		if _, ok := leaderboards[stored.CategoryID]; !ok {
+			leaderboards[stored.CategoryID] = make(map[int32]float64)
+		}
+		leaderboards[stored.CategoryID][stored.UserID] = stored.Rating
+	}
+
+	for categoryID, ratings := range leaderboards {
+		if cerr := rt.lbRepo.Set(categoryID, ratings); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+func defaultRating(userID, categoryID int32) *models.Rating {
+	return fromGlicko(userID, categoryID, glicko.Default())
+}
+
+func toGlicko(rating *models.Rating) glicko.Rating {
+	return glicko.Rating{
+		Rating:     rating.Rating,
+		Deviation:  rating.Deviation,
+		Volatility: rating.Volatility,
+	}
+}
+
+func fromGlicko(userID, categoryID int32, r glicko.Rating) *models.Rating {
+	return &models.Rating{
+		UserID:     userID,
+		CategoryID: categoryID,
+		Rating:     r.Rating,
+		Deviation:  r.Deviation,
+		Volatility: r.Volatility,
+	}
+}
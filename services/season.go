@@ -0,0 +1,253 @@
+package services
+
+import (
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/glicko"
+	"mmr/models"
+	"mmr/shared"
+	"sort"
+	"sync"
+	"time"
+)
+
+type SeasonRepository interface {
+	Create(season *models.Season) (int32, Cerr.CError)
+	Update(season *models.Season) Cerr.CError
+	Get(id int32) (*models.Season, Cerr.CError)
+	Current() (*models.Season, Cerr.CError)
+	List() ([]models.Season, Cerr.CError)
+	//SetStandings replaces the standings of the season
+	SetStandings(seasonID int32, standings []models.SeasonStanding) Cerr.CError
+	ListStandings(seasonID, categoryID int32, offset, limit int64) ([]models.SeasonStanding, Cerr.CError)
+}
+
+type SeasonConfig struct {
+	Length time.Duration
+	Reset  SoftReset
+}
+
+var DefaultSeasonConfig = SeasonConfig{
+	Length: 90 * 24 * time.Hour,
+	Reset: SoftReset{
+		Target:       glicko.DefaultRating,
+		Factor:       0.5,
+		MinDeviation: 200,
+	},
+}
+
+type Season struct {
+	repo      SeasonRepository
+	ratingSvc *Rating
+	lbSvc     *Leaderboard
+	clock     shared.Clock
+	cfg       SeasonConfig
+	//serializes opening and closing of seasons
+	mu sync.Mutex
+}
+
+func NewSeason(repo SeasonRepository, ratingSvc *Rating, lbSvc *Leaderboard, clock shared.Clock, cfg SeasonConfig) *Season {
+	return &Season{
+		repo:      repo,
+		ratingSvc: ratingSvc,
+		lbSvc:     lbSvc,
+		clock:     clock,
+		cfg:       cfg,
+		mu:        sync.Mutex{},
+	}
+}
+
+//Current returns the running season, starting the first one if there is none yet
+func (s *Season) Current() (*models.Season, Cerr.CError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.current()
+}
+
+func (s *Season) List() ([]models.Season, Cerr.CError) {
+	return s.repo.List()
+}
+
+//Standings returns a page of the standings of a category. Closed seasons are served from the archive,
+//the running season from the live leaderboard
+func (s *Season) Standings(seasonID, categoryID, userID int32, cursor string, limit int64) (*models.StandingsPage, Cerr.CError) {
+	season, cerr := s.repo.Get(seasonID)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	if !season.Closed {
+		lb, cerr := s.lbSvc.Get(categoryID, userID, cursor, limit)
+		if cerr != nil {
+			return nil, cerr
+		}
+
+		page := &models.StandingsPage{
+			Season:     season,
+			Entries:    make([]models.SeasonStanding, 0, len(lb.Entries)),
+			NextCursor: lb.NextCursor,
+		}
+		for _, entry := range lb.Entries {
+			page.Entries = append(page.Entries, models.SeasonStanding{
+				SeasonID:   season.Id,
+				CategoryID: categoryID,
+				UserID:     entry.UserID,
+				Rank:       entry.Rank,
+				Rating:     entry.Rating,
+			})
+		}
+		return page, nil
+	}
+
+	offset, cerr := decodeCursor(cursor)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if limit <= 0 {
+		limit = DefaultLeaderboardLimit
+	} else if limit > MaxLeaderboardLimit {
+		limit = MaxLeaderboardLimit
+	}
+
+	standings, cerr := s.repo.ListStandings(season.Id, categoryID, offset, limit+1)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	page := &models.StandingsPage{Season: season, Entries: standings}
+	if int64(len(standings)) > limit {
+		page.Entries = standings[:limit]
+		page.NextCursor = encodeCursor(offset + limit)
+	}
+
+	return page, nil
+}
+
+//Rollover closes the running season once it has ended: final ratings and ranks are archived,
+//ratings are soft reset and the next season is opened. The season is closed along with its standings before
+//any rating is reset, and each rating is marked once reset, so a failed rollover can be retried
+//without archiving reset ratings or resetting twice
+func (s *Season) Rollover() Cerr.CError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	season, cerr := s.current()
+	if cerr != nil {
+		return cerr
+	}
+	if s.clock.Now().Before(season.EndsAt) {
+		return nil
+	}
+
+	_, cerr = s.reset(season, func(final []models.Rating) Cerr.CError {
+		if cerr := s.repo.SetStandings(season.Id, rank(season.Id, final)); cerr != nil {
+			return cerr
+		}
+		season.Closed = true
+		return s.repo.Update(season)
+	})
+	return cerr
+}
+
+//Run calls Rollover every interval until stop is closed
+func (s *Season) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.Rollover()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//current must be called with the lock held
+func (s *Season) current() (*models.Season, Cerr.CError) {
+	season, cerr := s.repo.Current()
+	if _, ok := cerr.(Cerr.NotFound); !ok {
+		return season, cerr
+	}
+
+	seasons, cerr := s.repo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+	//a rollover stopped after closing the last season, finish it
+	if len(seasons) > 0 && !seasons[0].RatingsReset {
+		return s.reset(&seasons[0], nil)
+	}
+
+	return s.open(s.clock.Now())
+}
+
+//reset soft resets the ratings at the close of season and opens the next one, archive is passed on to
+//Rating.SoftReset. Must be called with the lock held
+func (s *Season) reset(season *models.Season, archive func(final []models.Rating) Cerr.CError) (*models.Season,
+	Cerr.CError) {
+	if cerr := s.ratingSvc.SoftReset(season.Id, s.cfg.Reset, archive); cerr != nil {
+		return nil, cerr
+	}
+
+	season.RatingsReset = true
+	if cerr := s.repo.Update(season); cerr != nil {
+		return nil, cerr
+	}
+
+	return s.open(season.EndsAt)
+}
+
+//open must be called with the lock held
+func (s *Season) open(startsAt time.Time) (*models.Season, Cerr.CError) {
+	seasons, cerr := s.repo.List()
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	season := &models.Season{
+		Name:     fmt.Sprintf("Season %d", len(seasons)+1),
+		StartsAt: startsAt,
+		EndsAt:   startsAt.Add(s.cfg.Length),
+	}
+	if _, cerr := s.repo.Create(season); cerr != nil {
+		return nil, cerr
+	}
+
+	return season, nil
+}
+
+//rank orders ratings within each category, highest rating first
+func rank(seasonID int32, ratings []models.Rating) []models.SeasonStanding {
+	sorted := append([]models.Rating(nil), ratings...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].CategoryID != sorted[j].CategoryID {
+			return sorted[i].CategoryID < sorted[j].CategoryID
+		}
+		if sorted[i].Rating == sorted[j].Rating {
+			return sorted[i].UserID < sorted[j].UserID
+		}
+		return sorted[i].Rating > sorted[j].Rating
+	})
+
+	standings := make([]models.SeasonStanding, 0, len(sorted))
+	var r int64
+	for i, rating := range sorted {
+		if i == 0 || sorted[i-1].CategoryID != rating.CategoryID {
+			r = 0
+		}
+		r++
+		standings = append(standings, models.SeasonStanding{
+			SeasonID:   seasonID,
+			CategoryID: rating.CategoryID,
+			UserID:     rating.UserID,
+			Rank:       r,
+			Rating:     rating.Rating,
+			Deviation:  rating.Deviation,
+		})
+	}
+
+	return standings
+}
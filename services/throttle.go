@@ -0,0 +1,221 @@
+package services
+
+import (
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ThrottleRepository interface {
+	//Reserve records an attempt at at under key, unless the attempts recorded so far make it wait delay(count) after
+	//the last one, in which case it returns the time left to wait. Checking and recording are atomic, so that
+	//parallel attempts can't all get through. Attempts are forgotten once none are recorded for window
+	Reserve(key string, at time.Time, window time.Duration, delay func(attempts int64) time.Duration) (time.Duration, Cerr.CError)
+	//Release forgets one attempt recorded under key
+	Release(key string) Cerr.CError
+	Reset(key string) Cerr.CError
+}
+
+//ThrottleRule backs off exponentially once the free attempts are used up: each failure past them
+//doubles the wait before the next attempt, until it reaches Lockout
+type ThrottleRule struct {
+	Free    int64
+	Base    time.Duration
+	Lockout time.Duration
+	//failures are forgotten once none happen for Window
+	Window time.Duration
+}
+
+//Delay returns how long to wait after the last of failures before trying again
+func (rule ThrottleRule) Delay(failures int64) time.Duration {
+	if failures < rule.Free {
+		return 0
+	}
+
+	delay := rule.Base
+	for i := rule.Free; i < failures && delay < rule.Lockout; i++ {
+		delay *= 2
+	}
+	if delay > rule.Lockout {
+		delay = rule.Lockout
+	}
+
+	return delay
+}
+
+type ThrottleConfig struct {
+	Account ThrottleRule
+	//an IP gets more attempts than an account, as many users can share one
+	IP ThrottleRule
+	//limits the magic links mailed to an address
+	MagicLink ThrottleRule
+	//limits the password reset links mailed to an address
+	PasswordReset ThrottleRule
+}
+
+var DefaultThrottleConfig = ThrottleConfig{
+	Account: ThrottleRule{
+		Free:    5,
+		Base:    30 * time.Second,
+		Lockout: 15 * time.Minute,
+		Window:  time.Hour,
+	},
+	IP: ThrottleRule{
+		Free:    20,
+		Base:    10 * time.Second,
+		Lockout: 15 * time.Minute,
+		Window:  time.Hour,
+	},
+	MagicLink: ThrottleRule{
+		Free:    3,
+		Base:    time.Minute,
+		Lockout: time.Hour,
+		Window:  time.Hour,
+	},
+	PasswordReset: ThrottleRule{
+		Free:    3,
+		Base:    time.Minute,
+		Lockout: time.Hour,
+		Window:  time.Hour,
+	},
+}
+
+//Throttle tracks failed logins per account and per IP, and rate limits other actions
+type Throttle struct {
+	repo  ThrottleRepository
+	clock shared.Clock
+	cfg   ThrottleConfig
+}
+
+func NewThrottle(repo ThrottleRepository, clock shared.Clock, cfg ThrottleConfig) *Throttle {
+	return &Throttle{
+		repo:  repo,
+		clock: clock,
+		cfg:   cfg,
+	}
+}
+
+//Reserve records a login attempt of the account from the IP, returning TooManyRequests instead if either has to wait.
+//Attempts count as failures until Succeed is called, so that parallel guesses all count before any is checked
+func (t *Throttle) Reserve(email, ip string) Cerr.CError {
+	return t.reserve(t.limits(email, ip))
+}
+
+//ReserveUser records an attempt of a logged in user at proving who they are again, with a password or a code,
+//returning TooManyRequests instead if they have to wait. Attempts are limited like the logins of an account and
+//count as failures until SucceedUser is called
+func (t *Throttle) ReserveUser(userID int32) Cerr.CError {
+	return t.reserve([]throttleLimit{{key: userThrottleKey(userID), rule: t.cfg.Account}})
+}
+
+//SucceedUser forgets the failed attempts of the user
+func (t *Throttle) SucceedUser(userID int32) Cerr.CError {
+	return t.repo.Reset(userThrottleKey(userID))
+}
+
+//CheckPassword confirms the password of a logged in user, limiting their attempts like ReserveUser
+func (t *Throttle) CheckPassword(usr *models.User, pass string) Cerr.CError {
+	if cerr := t.ReserveUser(usr.Id); cerr != nil {
+		return cerr
+	}
+	if err := usr.ValidatePass(pass); err != nil {
+		return Cerr.NewUnauthorized("password")
+	}
+
+	return t.SucceedUser(usr.Id)
+}
+
+//Hit records an attempt at an action limited by rule, returning TooManyRequests instead if it has to wait.
+//Unlike logins, every attempt counts, whether or not the action succeeds
+func (t *Throttle) Hit(key string, rule ThrottleRule) Cerr.CError {
+	return t.reserve([]throttleLimit{{key: key, rule: rule}})
+}
+
+//HitMagicLink records a magic link mailed to email, returning TooManyRequests instead if the address has to wait
+func (t *Throttle) HitMagicLink(email string) Cerr.CError {
+	return t.Hit(magicLinkThrottleKey(email), t.cfg.MagicLink)
+}
+
+//HitPasswordReset records a password reset link requested for email from ip, returning TooManyRequests instead if
+//either has to wait. An IP is limited like its logins, so that it can't mail every address a few links
+func (t *Throttle) HitPasswordReset(email, ip string) Cerr.CError {
+	limits := []throttleLimit{{key: passwordResetThrottleKey(email), rule: t.cfg.PasswordReset}}
+	if ip != "" {
+		limits = append(limits, throttleLimit{key: "reset:ip:" + ip, rule: t.cfg.IP})
+	}
+
+	return t.reserve(limits)
+}
+
+//Succeed forgets the failed logins of the account and the attempt reserved for the IP. Earlier failures of the IP
+//stay, so that a user can't reset them by logging into their own account between guesses at others
+func (t *Throttle) Succeed(email, ip string) Cerr.CError {
+	if cerr := t.repo.Reset(accountThrottleKey(email)); cerr != nil {
+		return cerr
+	}
+	if ip == "" {
+		return nil
+	}
+
+	return t.repo.Release(ipThrottleKey(ip))
+}
+
+type throttleLimit struct {
+	key  string
+	rule ThrottleRule
+}
+
+//reserve records an attempt under every limit, or none if any of them has to wait
+func (t *Throttle) reserve(limits []throttleLimit) Cerr.CError {
+	now := t.clock.Now()
+	for i, limit := range limits {
+		wait, cerr := t.repo.Reserve(limit.key, now, limit.rule.Window, limit.rule.Delay)
+		if cerr == nil && wait <= 0 {
+			continue
+		}
+
+		for _, reserved := range limits[:i] {
+			if rerr := t.repo.Release(reserved.key); rerr != nil {
+				return rerr
+			}
+		}
+		if cerr != nil {
+			return cerr
+		}
+		return Cerr.NewTooManyRequests(wait)
+	}
+
+	return nil
+}
+
+func (t *Throttle) limits(email, ip string) []throttleLimit {
+	limits := []throttleLimit{{key: accountThrottleKey(email), rule: t.cfg.Account}}
+	if ip != "" {
+		limits = append(limits, throttleLimit{key: ipThrottleKey(ip), rule: t.cfg.IP})
+	}
+
+	return limits
+}
+
+func accountThrottleKey(email string) string {
+	return "login:account:" + strings.ToLower(email)
+}
+
+func userThrottleKey(userID int32) string {
+	return "user:" + strconv.Itoa(int(userID))
+}
+
+func ipThrottleKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+func magicLinkThrottleKey(email string) string {
+	return "magic:" + strings.ToLower(email)
+}
+
+func passwordResetThrottleKey(email string) string {
+	return "reset:" + strings.ToLower(email)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	Cerr "mmr/errors"
+	"mmr/repositories/memRepos"
+	"testing"
+	"time"
+)
+
+var testRule = ThrottleRule{
+	Free:    2,
+	Base:    time.Minute,
+	Lockout: 4 * time.Minute,
+	Window:  time.Hour,
+}
+
+func newTestThrottle(clock *fakeClock) *Throttle {
+	return NewThrottle(memRepos.NewThrottle(), clock, ThrottleConfig{Account: testRule, IP: testRule})
+}
+
+//wait returns how long the user has to wait before their next attempt, 0 if they don't
+func wait(t *testing.T, throttle *Throttle, userID int32) time.Duration {
+	t.Helper()
+	cerr := throttle.ReserveUser(userID)
+	if cerr == nil {
+		return 0
+	}
+	tooMany, ok := cerr.(Cerr.TooManyRequests)
+	if !ok {
+		t.Fatalf("reserve: %v", cerr)
+	}
+
+	return tooMany.GetRetryAfter()
+}
+
+func TestThrottleRuleDelay(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{10, 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := testRule.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestThrottleBacksOffOnTheClock(t *testing.T) {
+	clock := newFakeClock()
+	throttle := newTestThrottle(clock)
+
+	for i := 0; i < 2; i++ {
+		if got := wait(t, throttle, 1); got != 0 {
+			t.Fatalf("free attempt %d waits %v", i+1, got)
+		}
+	}
+	if got := wait(t, throttle, 1); got != time.Minute {
+		t.Fatalf("attempt past the free ones waits %v, want %v", got, time.Minute)
+	}
+
+	clock.Advance(time.Minute)
+	if got := wait(t, throttle, 1); got != 0 {
+		t.Fatalf("attempt after the delay waits %v", got)
+	}
+	if got := wait(t, throttle, 1); got != 2*time.Minute {
+		t.Fatalf("next attempt waits %v, want %v", got, 2*time.Minute)
+	}
+}
+
+func TestThrottleForgetsAfterWindow(t *testing.T) {
+	clock := newFakeClock()
+	throttle := newTestThrottle(clock)
+
+	for i := 0; i < 3; i++ {
+		_ = wait(t, throttle, 1)
+		clock.Advance(testRule.Delay(int64(i + 1)))
+	}
+	//past the lockout, but well within the window
+	clock.Advance(testRule.Window / 2)
+	if got := wait(t, throttle, 1); got != 0 {
+		t.Fatalf("attempt after the lockout waits %v", got)
+	}
+	if got := wait(t, throttle, 1); got != testRule.Lockout {
+		t.Fatalf("attempts were forgotten within the window, next waits %v", got)
+	}
+
+	clock.Advance(testRule.Window + time.Second)
+	for i := 0; i < 2; i++ {
+		if got := wait(t, throttle, 1); got != 0 {
+			t.Fatalf("attempt %d after the window waits %v", i+1, got)
+		}
+	}
+}
+
+func TestThrottleSucceedUserForgets(t *testing.T) {
+	clock := newFakeClock()
+	throttle := newTestThrottle(clock)
+
+	for i := 0; i < 2; i++ {
+		_ = wait(t, throttle, 1)
+	}
+	if cerr := throttle.SucceedUser(1); cerr != nil {
+		t.Fatalf("succeed: %v", cerr)
+	}
+	if got := wait(t, throttle, 1); got != 0 {
+		t.Fatalf("attempt after success waits %v", got)
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"mmr/shared"
+	"mmr/totp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PurposeLoginChallenge = "login_challenge"
+	loginChallengeTTL     = 5 * time.Minute
+	recoveryCodeCount     = 10
+	//codes of the steps right before and after the current one are accepted too, to allow for clock drift
+	totpSkew = 1
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactor struct {
+	usrRepo UserRepository
+	ottRepo OneTimeTokenRepository
+	//TOTP secrets are stored sealed
+	box *shared.SecretBox
+	//limits the codes a user can try
+	throttle *Throttle
+	clock    shared.Clock
+	//name authenticator apps show the account under
+	issuer string
+}
+
+func NewTwoFactor(usrRepo UserRepository, ottRepo OneTimeTokenRepository, box *shared.SecretBox, throttle *Throttle,
+	clock shared.Clock, issuer string) *TwoFactor {
+	return &TwoFactor{
+		usrRepo:  usrRepo,
+		ottRepo:  ottRepo,
+		box:      box,
+		throttle: throttle,
+		clock:    clock,
+		issuer:   issuer,
+	}
+}
+
+//Enroll generates a new TOTP secret for the user. It only takes effect once confirmed with a code
+func (tf *TwoFactor) Enroll(userID int32) (*models.TOTPEnrollment, Cerr.CError) {
+	usr, cerr := tf.usrRepo.FindById(userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if usr.TOTPEnabled {
+		return nil, Cerr.NewConflict("Two-factor authentication already enabled")
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't generate TOTP secret: %v\n", err)
+		return nil, Cerr.NewInternal()
+	}
+	sealed, err := tf.box.Seal(secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't seal TOTP secret: %v\n", err)
+		return nil, Cerr.NewInternal()
+	}
+
+	usr.TOTPSecret = sealed
+	usr.TOTPLastStep = 0
+	if cerr = tf.usrRepo.Update(usr); cerr != nil {
+		return nil, cerr
+	}
+
+	return &models.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(tf.issuer, usr.Email, secret),
+	}, nil
+}
+
+//Confirm enables two-factor authentication once the user proves their app generates valid codes.
+//It returns the recovery codes, which are never shown again
+func (tf *TwoFactor) Confirm(userID int32, code string) ([]string, Cerr.CError) {
+	usr, cerr := tf.usrRepo.FindById(userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if usr.TOTPEnabled {
+		return nil, Cerr.NewConflict("Two-factor authentication already enabled")
+	}
+	if usr.TOTPSecret == "" {
+		return nil, Cerr.NewConflict("Two-factor authentication not enrolled")
+	}
+
+	ok, cerr := tf.throttled(usr, func() (bool, Cerr.CError) {
+		return tf.checkTOTP(usr, code)
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !ok {
+		return nil, Cerr.NewUnauthorized("code")
+	}
+
+	codes, hashes, cerr := newRecoveryCodes()
+	if cerr != nil {
+		return nil, cerr
+	}
+	usr.TOTPEnabled = true
+	usr.RecoveryCodes = hashes
+	if cerr = tf.usrRepo.Update(usr); cerr != nil {
+		return nil, cerr
+	}
+
+	return codes, nil
+}
+
+//Disable turns two-factor authentication off, given a code or a recovery code
+func (tf *TwoFactor) Disable(userID int32, code string) Cerr.CError {
+	usr, cerr := tf.usrRepo.FindById(userID)
+	if cerr != nil {
+		return cerr
+	}
+	if !usr.TOTPEnabled {
+		return Cerr.NewConflict("Two-factor authentication not enabled")
+	}
+
+	ok, cerr := tf.throttled(usr, func() (bool, Cerr.CError) {
+		return tf.check(usr, code)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if !ok {
+		return Cerr.NewUnauthorized("code")
+	}
+
+	usr.TOTPSecret = ""
+	usr.TOTPEnabled = false
+	usr.TOTPLastStep = 0
+	usr.RecoveryCodes = nil
+	return tf.usrRepo.Update(usr)
+}
+
+//Challenge issues the short-lived token a login of the user is completed with
+func (tf *TwoFactor) Challenge(userID int32) (string, Cerr.CError) {
+	return issueOneTimeToken(tf.ottRepo, &models.OneTimeToken{
+		Purpose: PurposeLoginChallenge,
+		UserID:  userID,
+	}, loginChallengeTTL)
+}
+
+//Answer checks a code or a recovery code against the challenge and returns the user it was issued to.
+//The challenge is consumed either way, a wrong code means logging in again
+func (tf *TwoFactor) Answer(challenge, code string) (int32, Cerr.CError) {
+	token, cerr := redeemOneTimeToken(tf.ottRepo, PurposeLoginChallenge, challenge)
+	if cerr != nil {
+		return 0, cerr
+	}
+
+	usr, cerr := tf.usrRepo.FindById(token.UserID)
+	if cerr != nil {
+		return 0, cerr
+	}
+	if !usr.TOTPEnabled {
+		return 0, Cerr.NewUnauthorized("token")
+	}
+
+	ok, cerr := tf.throttled(usr, func() (bool, Cerr.CError) {
+		return tf.check(usr, code)
+	})
+	if cerr != nil {
+		return 0, cerr
+	}
+	if !ok {
+		return 0, Cerr.NewUnauthorized("code")
+	}
+
+	return usr.Id, nil
+}
+
+//throttled runs check unless the user tried too many wrong codes lately. Logging in again doesn't reset them,
+//so knowing the password isn't enough to keep guessing
+func (tf *TwoFactor) throttled(usr *models.User, check func() (bool, Cerr.CError)) (bool, Cerr.CError) {
+	if cerr := tf.throttle.ReserveUser(usr.Id); cerr != nil {
+		return false, cerr
+	}
+	ok, cerr := check()
+	if cerr != nil || !ok {
+		return ok, cerr
+	}
+
+	return true, tf.throttle.SucceedUser(usr.Id)
+}
+
+//check accepts a TOTP code or consumes a recovery code. Either is stored right away and atomically, so that
+//concurrent checks can't both use the same code. usr is updated to match
+func (tf *TwoFactor) check(usr *models.User, code string) (bool, Cerr.CError) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return tf.checkTOTP(usr, code)
+	}
+
+	hash := hashSecret(normalizeRecoveryCode(code))
+	ok, cerr := tf.usrRepo.UseRecoveryCode(usr.Id, hash)
+	if cerr != nil || !ok {
+		return false, cerr
+	}
+	remaining := make([]string, 0, len(usr.RecoveryCodes))
+	for _, stored := range usr.RecoveryCodes {
+		if stored != hash {
+			remaining = append(remaining, stored)
+		}
+	}
+	usr.RecoveryCodes = remaining
+
+	return true, nil
+}
+
+//checkTOTP accepts a code of a step later than the last accepted one, see check
+func (tf *TwoFactor) checkTOTP(usr *models.User, code string) (bool, Cerr.CError) {
+	secret, err := tf.box.Open(usr.TOTPSecret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't open TOTP secret of user %d: %v\n", usr.Id, err)
+		return false, Cerr.NewInternal()
+	}
+
+	step, ok := totp.Validate(secret, code, tf.clock.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	if ok, cerr := tf.usrRepo.UseTOTPStep(usr.Id, step); cerr != nil || !ok {
+		return false, cerr
+	}
+	usr.TOTPLastStep = step
+
+	return true, nil
+}
+
+//newRecoveryCodes returns the codes to show the user and the hashes to store
+func newRecoveryCodes() ([]string, []string, Cerr.CError) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't generate recovery code: %v\n", err)
+			return nil, nil, Cerr.NewInternal()
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(buf))
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		hashes = append(hashes, hashSecret(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
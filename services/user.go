@@ -9,6 +9,12 @@ type UserRepository interface {
 	Create(user *models.User) (int32, Cerr.CError)
 	FindById(userID int32) (*models.User, Cerr.CError)
 	FindByEmail(email string) (*models.User, Cerr.CError)
+	Update(user *models.User) Cerr.CError
+	//UseTOTPStep records step as the last accepted TOTP step of the user unless one as late was recorded already,
+	//atomically, and reports whether it did
+	UseTOTPStep(userID int32, step int64) (bool, Cerr.CError)
+	//UseRecoveryCode removes the recovery code with hash from the user atomically and reports whether they had it
+	UseRecoveryCode(userID int32, hash string) (bool, Cerr.CError)
 }
 
 type User struct {
@@ -32,3 +38,53 @@ func (usr *User) Find(userID int32) (*models.User, Cerr.CError) {
 
 	return dbUsr, nil
 }
+
+//SetRoles replaces the roles of a user. Changes reach the user's access tokens on their next refresh.
+//actorID is the user making the change, who can't take away their own right to manage roles
+func (usr *User) SetRoles(actorID, userID int32, roles []string) (*models.User, Cerr.CError) {
+	set := make([]string, 0, len(roles))
+	seen := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		if !models.ValidRole(role) {
+			return nil, Cerr.NewBadRequest("role")
+		}
+		//RoleUser is implicit
+		if role == models.RoleUser || seen[role] {
+			continue
+		}
+		seen[role] = true
+		set = append(set, role)
+	}
+	if actorID == userID && !models.HasPermission(set, models.PermManageRoles) {
+		return nil, Cerr.NewConflict("You can't remove your own permission to manage roles")
+	}
+
+	dbUsr, cerr := usr.repo.FindById(userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	dbUsr.Roles = set
+	if cerr := usr.repo.Update(dbUsr); cerr != nil {
+		return nil, cerr
+	}
+
+	dbUsr.Pass = ""
+	return dbUsr, nil
+}
+
+//UpdateProfile applies the profile fields set in changes to the user. Fields left empty are kept
+func (usr *User) UpdateProfile(userID int32, changes *models.User) (*models.User, Cerr.CError) {
+	dbUsr, cerr := usr.repo.FindById(userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if changes.Name != "" {
+		dbUsr.Name = changes.Name
+	}
+	if cerr := usr.repo.Update(dbUsr); cerr != nil {
+		return nil, cerr
+	}
+
+	dbUsr.Pass = ""
+	return dbUsr, nil
+}
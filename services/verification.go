@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	Cerr "mmr/errors"
+	"mmr/models"
+	"os"
+	"time"
+)
+
+const (
+	PurposeEmailVerification = "email_verification"
+	emailVerificationTTL     = 24 * time.Hour
+)
+
+//Policy decides whether the user may use a feature, returning the error to reject them with
+type Policy func(userID int32) Cerr.CError
+
+type Verification struct {
+	usrRepo  UserRepository
+	ottRepo  OneTimeTokenRepository
+	throttle *Throttle
+	mailer   Mailer
+	//links in emails point to the frontend served at baseURL
+	baseURL string
+}
+
+func NewVerification(usrRepo UserRepository, ottRepo OneTimeTokenRepository, throttle *Throttle, mailer Mailer,
+	baseURL string) *Verification {
+	return &Verification{
+		usrRepo:  usrRepo,
+		ottRepo:  ottRepo,
+		throttle: throttle,
+		mailer:   mailer,
+		baseURL:  baseURL,
+	}
+}
+
+//Registered is a registration hook that mails the verification link to the new user, unless their email is
+//already known to be theirs
+func (v *Verification) Registered(usr *models.User) {
+	if usr.Verified {
+		return
+	}
+	if cerr := v.send(usr); cerr != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't send verification email to user %d: %v\n", usr.Id, cerr)
+	}
+}
+
+//Resend mails a new verification link to the user
+func (v *Verification) Resend(userID int32) Cerr.CError {
+	usr, cerr := v.usrRepo.FindById(userID)
+	if cerr != nil {
+		return cerr
+	}
+	if usr.Verified {
+		return Cerr.NewConflict("Email already verified")
+	}
+
+	return v.send(usr)
+}
+
+//Verify marks the email the token was mailed to as verified, as long as it is still the email of the user
+func (v *Verification) Verify(secret string) Cerr.CError {
+	token, cerr := redeemOneTimeToken(v.ottRepo, PurposeEmailVerification, secret)
+	if cerr != nil {
+		return cerr
+	}
+
+	usr, cerr := v.usrRepo.FindById(token.UserID)
+	if cerr != nil {
+		return cerr
+	}
+	if usr.Email != token.Email {
+		return Cerr.NewUnauthorized("token")
+	}
+	if usr.Verified {
+		return nil
+	}
+
+	usr.Verified = true
+	return v.usrRepo.Update(usr)
+}
+
+//ChangeEmail moves the user to a new email once they confirmed their password. The new email is unverified
+//until the user follows the link mailed to it, links mailed to the old email stop working.
+//Wrong guesses at the password are throttled per user
+func (v *Verification) ChangeEmail(userID int32, email, pass string) (*models.User, Cerr.CError) {
+	usr, cerr := v.usrRepo.FindById(userID)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if cerr = v.throttle.CheckPassword(usr, pass); cerr != nil {
+		return nil, cerr
+	}
+	if usr.Email == email {
+		return nil, Cerr.NewConflict("Email unchanged")
+	}
+
+	usr.Email = email
+	usr.Verified = false
+	if cerr = v.usrRepo.Update(usr); cerr != nil {
+		return nil, cerr
+	}
+	if cerr = v.send(usr); cerr != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't send verification email to user %d: %v\n", usr.Id, cerr)
+	}
+
+	usr.Pass = ""
+	return usr, nil
+}
+
+//RequireVerified is a Policy that only lets users with a verified email through
+func (v *Verification) RequireVerified(userID int32) Cerr.CError {
+	usr, cerr := v.usrRepo.FindById(userID)
+	if cerr != nil {
+		return cerr
+	}
+	if !usr.Verified {
+		return Cerr.NewForbidden("feature until your email is verified")
+	}
+
+	return nil
+}
+
+func (v *Verification) send(usr *models.User) Cerr.CError {
+	secret, cerr := issueOneTimeToken(v.ottRepo, &models.OneTimeToken{
+		Purpose: PurposeEmailVerification,
+		UserID:  usr.Id,
+		Email:   usr.Email,
+	}, emailVerificationTTL)
+	if cerr != nil {
+		return cerr
+	}
+
+	body := fmt.Sprintf("Confirm that %s is your email address by following %s/verify-email?token=%s within %d hours.",
+		usr.Email, v.baseURL, secret, int(emailVerificationTTL.Hours()))
+	if err := v.mailer.Send(usr.Email, "Verify your email", body); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't send verification email: %v\n", err)
+		return Cerr.NewInternal()
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package shared
+
+import "time"
+
+//Clock abstracts time.Now and timers so that time dependent logic can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+	//AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+//Timer is a call scheduled by Clock.AfterFunc
+type Timer interface {
+	//Stop prevents the call, returning false if it already happened or was stopped
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+var SystemClock Clock = systemClock{}
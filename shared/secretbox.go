@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+//SecretBox encrypts secrets stored at rest with AES-GCM
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+//NewSecretBox takes a 16, 24 or 32 byte key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+//Seal encrypts plaintext and returns the nonce and ciphertext base64 encoded
+func (b *SecretBox) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+//Open decrypts what Seal returned
+func (b *SecretBox) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < b.aead.NonceSize() {
+		return "", errors.New("sealed secret too short")
+	}
+
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}